@@ -9,8 +9,12 @@ import (
 )
 
 type Server struct {
-	Router *gin.Engine
-	Port   string
+	Router   *gin.Engine
+	Port     string
+	GrpcHost string
+	GrpcPort string
+	// Address of the internal metrics listener
+	MetricsAddr string
 }
 
 func (s *Server) IntiServer() error {
@@ -25,11 +29,26 @@ func (s *Server) IntiServer() error {
 	if port == "" {
 		port = "8080" // Default port if PORT env is not set
 	}
+	grpcHost := os.Getenv("GRPC_HOST")
+	if grpcHost == "" {
+		grpcHost = "127.0.0.1" // Only local services reach the gRPC server unless GRPC_HOST says otherwise
+	}
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "50051" // Default gRPC port if GRPC_PORT env is not set
+	}
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = "127.0.0.1:9100" // Metrics stay on the node unless METRICS_ADDR says otherwise
+	}
 	router := gin.Default()
 	log.Printf("Server running at port: %s", port)
 
 	s.Router = router
 	s.Port = port
+	s.GrpcHost = grpcHost
+	s.GrpcPort = grpcPort
+	s.MetricsAddr = metricsAddr
 
 	return nil
 }
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"log"
 	"os"
 )
 
@@ -15,7 +16,11 @@ func DB_init() {
 	valkey_password := os.Getenv("VALKEY_PASSWORD")
 	Valkey = NewValkeyChatStore(valkey_endpoint, valkey_password, 2) //this `2` is for the room information partation
 
-	// if err := ConnectPostgres(); err != nil {
-	// 	log.Fatalf("Failed to connect to PostgreSQL: %v", err)
-	// }
+	if err := ConnectPostgres(); err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+
+	if err := InitMessageStore(); err != nil {
+		log.Fatalf("Failed to initialize message store: %v", err)
+	}
 }
@@ -0,0 +1,392 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"raychat/models"
+	"strconv"
+	"time"
+)
+
+// messageSchema is applied in order on startup, every statement must be safe to run again
+var messageSchema = []string{
+	`CREATE TABLE IF NOT EXISTS messages (
+		row_id    BIGSERIAL PRIMARY KEY,
+		id        TEXT NOT NULL UNIQUE,
+		room_id   TEXT NOT NULL,
+		sender_id TEXT NOT NULL,
+		content   TEXT NOT NULL,
+		type      TEXT NOT NULL,
+		timestamp BIGINT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS messages_room_row_idx ON messages (room_id, row_id DESC)`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted BOOLEAN NOT NULL DEFAULT FALSE`,
+	`CREATE TABLE IF NOT EXISTS message_edits (
+		message_id TEXT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+		content    TEXT NOT NULL,
+		edited_by  TEXT NOT NULL,
+		edited_at  BIGINT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS message_edits_message_idx ON message_edits (message_id, edited_at)`,
+	`CREATE TABLE IF NOT EXISTS message_reactions (
+		message_id TEXT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+		user_id    TEXT NOT NULL,
+		emoji      TEXT NOT NULL,
+		reacted_at BIGINT NOT NULL,
+		PRIMARY KEY (message_id, user_id, emoji)
+	)`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS last_reply_at BIGINT NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS messages_parent_row_idx ON messages (parent_id, row_id DESC) WHERE parent_id <> ''`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS messages_room_seq_idx ON messages (room_id, seq)`,
+	`CREATE TABLE IF NOT EXISTS room_sequences (
+		room_id TEXT PRIMARY KEY,
+		seq     BIGINT NOT NULL
+	)`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at BIGINT NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS messages_expires_idx ON messages (expires_at) WHERE expires_at > 0`,
+	`CREATE TABLE IF NOT EXISTS room_events (
+		room_id    TEXT NOT NULL,
+		seq        BIGINT NOT NULL,
+		target_id  TEXT NOT NULL,
+		message    TEXT NOT NULL,
+		created_at BIGINT NOT NULL,
+		PRIMARY KEY (room_id, seq)
+	)`,
+	`CREATE INDEX IF NOT EXISTS room_events_created_idx ON room_events (created_at)`,
+	`CREATE INDEX IF NOT EXISTS room_events_target_idx ON room_events (target_id) WHERE target_id <> ''`,
+}
+
+// Columns read by every message query, in the order scanMessage expects them
+const messageColumns = `id, room_id, sender_id, content, type, timestamp, edited_at, deleted, parent_id, reply_count, last_reply_at, seq, expires_at`
+
+// InitMessageStore creates or upgrades the message history tables
+func InitMessageStore() error {
+	for _, statement := range messageSchema {
+		if _, err := PostgresDB.Exec(statement); err != nil {
+			return fmt.Errorf("failed to apply message schema: %w", err)
+		}
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMessage reads a message selected with messageColumns, plus any extra columns after them
+func scanMessage(row rowScanner, extra ...any) (*models.Message, error) {
+	msg := &models.Message{}
+	dest := append([]any{
+		&msg.ID, &msg.RoomID, &msg.SenderID, &msg.Content, &msg.Type, &msg.Timestamp,
+		&msg.EditedAt, &msg.Deleted, &msg.ParentID, &msg.ReplyCount, &msg.LastReplyAt, &msg.Seq,
+		&msg.ExpiresAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// nextSeq takes the room's next sequence number, shared by its messages and change events
+// The counter row stays locked until commit, so they are numbered in the order they are stored
+func nextSeq(tx *sql.Tx, roomID string) (int64, error) {
+	var seq int64
+	err := tx.QueryRow(
+		`INSERT INTO room_sequences (room_id, seq) VALUES ($1, 1)
+		 ON CONFLICT (room_id) DO UPDATE SET seq = room_sequences.seq + 1
+		 RETURNING seq`,
+		roomID,
+	).Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to assign sequence number: %w", err)
+	}
+
+	return seq, nil
+}
+
+// SaveMessage stores a broadcast message in the room history and sets its Seq to the
+// room's next sequence number. A reply also bumps the thread summary of its parent message
+func SaveMessage(msg *models.Message) error {
+	tx, err := PostgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin save: %w", err)
+	}
+	defer tx.Rollback()
+
+	if msg.Seq, err = nextSeq(tx, msg.RoomID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO messages (id, room_id, sender_id, content, type, timestamp, parent_id, seq, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		msg.ID, msg.RoomID, msg.SenderID, msg.Content, msg.Type, msg.Timestamp, msg.ParentID, msg.Seq, msg.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+
+	if msg.ParentID != "" {
+		_, err = tx.Exec(
+			`UPDATE messages SET reply_count = reply_count + 1, last_reply_at = $2 WHERE id = $1`,
+			msg.ParentID, msg.Timestamp,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update thread summary: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRoomMessages returns up to limit top-level messages older than the before cursor, oldest first.
+// An empty cursor starts from the newest message. The returned cursor is empty when
+// there is nothing older left to fetch. Thread replies are fetched with GetThreadReplies.
+func GetRoomMessages(roomID, before string, limit int) ([]*models.Message, string, error) {
+	return queryMessagePage(`room_id = $1 AND parent_id = ''`, roomID, before, limit)
+}
+
+// GetThreadReplies returns a page of replies to the parent message, paginated like GetRoomMessages
+func GetThreadReplies(parentID, before string, limit int) ([]*models.Message, string, error) {
+	return queryMessagePage(`parent_id = $1`, parentID, before, limit)
+}
+
+// queryMessagePage runs a paginated message query, filter uses $1 for arg
+func queryMessagePage(filter, arg, before string, limit int) ([]*models.Message, string, error) {
+	var beforeRow int64
+	if before != "" {
+		var err error
+		beforeRow, err = strconv.ParseInt(before, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor: %q", before)
+		}
+	}
+
+	rows, err := PostgresDB.Query(
+		`SELECT `+messageColumns+`, row_id
+		 FROM messages
+		 WHERE `+filter+` AND ($2 = 0 OR row_id < $2) AND (expires_at = 0 OR expires_at > $4)
+		 ORDER BY row_id DESC
+		 LIMIT $3`,
+		arg, beforeRow, limit, time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]*models.Message, 0, limit)
+	var lastRow int64
+	for rows.Next() {
+		msg, err := scanMessage(rows, &lastRow)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to read messages: %w", err)
+	}
+
+	// Rows come newest first, clients expect them in the order they were sent
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	nextCursor := ""
+	if len(messages) == limit {
+		nextCursor = strconv.FormatInt(lastRow, 10)
+	}
+
+	return messages, nextCursor, nil
+}
+
+// GetRoomMessagesAfter returns up to limit messages of the room stored after the afterSeq
+// sequence number, thread replies included, in sequence order
+func GetRoomMessagesAfter(roomID string, afterSeq int64, limit int) ([]*models.Message, error) {
+	rows, err := PostgresDB.Query(
+		`SELECT `+messageColumns+`
+		 FROM messages
+		 WHERE room_id = $1 AND seq > $2 AND (expires_at = 0 OR expires_at > $4)
+		 ORDER BY seq
+		 LIMIT $3`,
+		roomID, afterSeq, limit, time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]*models.Message, 0, limit)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// GetMessage returns a stored message by ID
+func GetMessage(messageID string) (*models.Message, error) {
+	row := PostgresDB.QueryRow(
+		`SELECT `+messageColumns+` FROM messages WHERE id = $1 AND (expires_at = 0 OR expires_at > $2)`,
+		messageID, time.Now().Unix(),
+	)
+
+	msg, err := scanMessage(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("message %s not found", messageID)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	return msg, nil
+}
+
+// EditMessage replaces the message's content and keeps the previous content as a revision
+func EditMessage(messageID, content, editorID string, editedAt int64) error {
+	tx, err := PostgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin edit: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO message_edits (message_id, content, edited_by, edited_at)
+		 SELECT id, content, $2, $3 FROM messages WHERE id = $1 AND NOT deleted`,
+		messageID, editorID, editedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store revision: %w", err)
+	}
+
+	result, err := tx.Exec(
+		`UPDATE messages SET content = $2, edited_at = $3 WHERE id = $1 AND NOT deleted`,
+		messageID, content, editedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return fmt.Errorf("message %s not found", messageID)
+	}
+
+	return tx.Commit()
+}
+
+// DeleteMessage replaces the message with a tombstone, its content, revisions and reactions are dropped
+func DeleteMessage(messageID string, deletedAt int64) error {
+	tx, err := PostgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin delete: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE messages SET content = '', deleted = TRUE, edited_at = $2 WHERE id = $1 AND NOT deleted`,
+		messageID, deletedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return fmt.Errorf("message %s not found", messageID)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id = $1`, messageID); err != nil {
+		return fmt.Errorf("failed to drop revisions: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_reactions WHERE message_id = $1`, messageID); err != nil {
+		return fmt.Errorf("failed to drop reactions: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetMessageRevisions returns the previous contents of an edited message, oldest first
+func GetMessageRevisions(messageID string) ([]models.MessageRevision, error) {
+	rows, err := PostgresDB.Query(
+		`SELECT content, edited_by, edited_at FROM message_edits WHERE message_id = $1 ORDER BY edited_at`,
+		messageID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := make([]models.MessageRevision, 0)
+	for rows.Next() {
+		var revision models.MessageRevision
+		if err := rows.Scan(&revision.Content, &revision.EditedBy, &revision.EditedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revision: %w", err)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+// DeleteRoomMessages drops the room's history, change events and sequence counter, revisions and reactions go with the messages
+func DeleteRoomMessages(roomID string) error {
+	tx, err := PostgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin room delete: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM messages WHERE room_id = $1`, roomID); err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM room_events WHERE room_id = $1`, roomID); err != nil {
+		return fmt.Errorf("failed to delete events: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM room_sequences WHERE room_id = $1`, roomID); err != nil {
+		return fmt.Errorf("failed to delete sequence: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// DeleteExpiredMessages drops up to limit messages whose expiry has passed and returns them,
+// each one is returned to a single caller however many nodes sweep at once
+func DeleteExpiredMessages(now int64, limit int) ([]*models.Message, error) {
+	rows, err := PostgresDB.Query(
+		`DELETE FROM messages
+		 WHERE id IN (
+			SELECT id FROM messages
+			WHERE expires_at > 0 AND expires_at <= $1
+			ORDER BY expires_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+messageColumns,
+		now, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired messages: %w", err)
+	}
+	defer rows.Close()
+
+	expired := make([]*models.Message, 0)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		expired = append(expired, msg)
+	}
+
+	return expired, rows.Err()
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"fmt"
+	"raychat/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ToggleReaction adds the user's reaction to the message, or removes it if it was already there
+// It reports whether the reaction was added
+func ToggleReaction(messageID, userID, emoji string) (bool, error) {
+	result, err := PostgresDB.Exec(
+		`DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`,
+		messageID, userID, emoji,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove reaction: %w", err)
+	}
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		return false, nil
+	}
+
+	_, err = PostgresDB.Exec(
+		`INSERT INTO message_reactions (message_id, user_id, emoji, reacted_at)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT DO NOTHING`,
+		messageID, userID, emoji, time.Now().Unix(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to add reaction: %w", err)
+	}
+
+	return true, nil
+}
+
+// GetReactionSummaries returns the aggregated reactions of each message, keyed by message ID
+// Messages without reactions are left out
+func GetReactionSummaries(messageIDs []string) (map[string][]models.ReactionSummary, error) {
+	summaries := make(map[string][]models.ReactionSummary)
+	if len(messageIDs) == 0 {
+		return summaries, nil
+	}
+
+	rows, err := PostgresDB.Query(
+		`SELECT message_id, emoji, COUNT(*), array_agg(user_id ORDER BY reacted_at)
+		 FROM message_reactions
+		 WHERE message_id = ANY($1)
+		 GROUP BY message_id, emoji
+		 ORDER BY message_id, MIN(reacted_at)`,
+		pq.Array(messageIDs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var summary models.ReactionSummary
+		if err := rows.Scan(&messageID, &summary.Emoji, &summary.Count, pq.Array(&summary.Users)); err != nil {
+			return nil, fmt.Errorf("failed to scan reaction: %w", err)
+		}
+		summaries[messageID] = append(summaries[messageID], summary)
+	}
+
+	return summaries, rows.Err()
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"raychat/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SaveRoomEvent numbers a broadcast that isn't a stored message, such as an edit, a reaction
+// or a system notice, in the room's sequence and keeps it so resuming clients get it too
+func SaveRoomEvent(msg *models.Message) error {
+	tx, err := PostgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin event save: %w", err)
+	}
+	defer tx.Rollback()
+
+	if msg.Seq, err = nextSeq(tx, msg.RoomID); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO room_events (room_id, seq, target_id, message, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		msg.RoomID, msg.Seq, msg.TargetID, string(data), time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save event: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetRoomEventsAfter returns up to limit change events of the room after the afterSeq sequence number, in sequence order
+func GetRoomEventsAfter(roomID string, afterSeq int64, limit int) ([]*models.Message, error) {
+	rows, err := PostgresDB.Query(
+		`SELECT message FROM room_events
+		 WHERE room_id = $1 AND seq > $2
+		 ORDER BY seq
+		 LIMIT $3`,
+		roomID, afterSeq, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]*models.Message, 0, limit)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+
+		event := &models.Message{}
+		if err := json.Unmarshal([]byte(data), event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// DeleteRoomEvents drops the change events made to the given messages
+func DeleteRoomEvents(messageIDs []string) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	if _, err := PostgresDB.Exec(`DELETE FROM room_events WHERE target_id = ANY($1)`, pq.Array(messageIDs)); err != nil {
+		return fmt.Errorf("failed to delete events: %w", err)
+	}
+
+	return nil
+}
+
+// PruneRoomEvents drops the change events stored before the given time
+func PruneRoomEvents(before int64) error {
+	if _, err := PostgresDB.Exec(`DELETE FROM room_events WHERE created_at < $1`, before); err != nil {
+		return fmt.Errorf("failed to prune events: %w", err)
+	}
+
+	return nil
+}
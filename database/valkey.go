@@ -77,30 +77,29 @@ func (s *ValkeyChatStore) AddUserToRoom(userID, roomID string) error {
 	return s.Client.SAdd(s.Ctx, roomMembersKey, userID).Err()
 }
 
-// // RemoveUserFromRoom removes a user from a room
-// func (s *ValkeyChatStore) RemoveUserFromRoom(userID, roomID string) error {
-// 	// Remove room from user's room list
-// 	userRoomsKey := "chat:user:" + userID + ":rooms"
-// 	if err := s.Client.SRem(s.Ctx, userRoomsKey, roomID).Err(); err != nil {
-// 		return err
-// 	}
+// RemoveUserFromRoom removes a user from a room
+func (s *ValkeyChatStore) RemoveUserFromRoom(userID, roomID string) error {
+	// Remove room from user's room list
+	userRoomsKey := "chat:user:" + userID + ":rooms"
+	if err := s.Client.SRem(s.Ctx, userRoomsKey, roomID).Err(); err != nil {
+		return err
+	}
 
-// 	// Remove user from room's authorized members
-// 	roomMembersKey := "chat:room:" + roomID + ":auth"
-// 	if err := s.Client.SRem(s.Ctx, roomMembersKey, userID).Err(); err != nil {
-// 		return err
-// 	}
+	// Remove user from room's authorized members
+	roomMembersKey := "chat:room:" + roomID + ":auth"
+	if err := s.Client.SRem(s.Ctx, roomMembersKey, userID).Err(); err != nil {
+		return err
+	}
 
-// 	// Remove user from room's active members
-// 	roomActiveKey := "chat:room:" + roomID + ":active"
-// 	return s.Client.SRem(s.Ctx, roomActiveKey, userID).Err()
-// }
+	// Remove user from room's active members
+	return s.clearActiveUser(roomID, userID)
+}
 
-// // GetUserRooms retrieves all rooms a user is authorized for
-// func (s *ValkeyChatStore) GetUserRooms(userID string) ([]string, error) {
-// 	userRoomsKey := "chat:user:" + userID + ":rooms"
-// 	return s.Client.SMembers(s.Ctx, userRoomsKey).Result()
-// }
+// GetUserRooms retrieves all rooms a user is authorized for
+func (s *ValkeyChatStore) GetUserRooms(userID string) ([]string, error) {
+	userRoomsKey := "chat:user:" + userID + ":rooms"
+	return s.Client.SMembers(s.Ctx, userRoomsKey).Result()
+}
 
 // // IsUserAuthorizedForRoom checks if a user is authorized for a room
 // func (s *ValkeyChatStore) IsUserAuthorizedForRoom(userID, roomID string) (bool, error) {
@@ -108,24 +107,6 @@ func (s *ValkeyChatStore) AddUserToRoom(userID, roomID string) error {
 // 	return s.Client.SIsMember(s.Ctx, roomMembersKey, userID).Result()
 // }
 
-// // SetUserActive marks a user as active in a room
-// func (s *ValkeyChatStore) SetUserActive(userID, roomID string) error {
-// 	roomActiveKey := "chat:room:" + roomID + ":active"
-// 	return s.Client.SAdd(s.Ctx, roomActiveKey, userID).Err()
-// }
-
-// // SetUserInactive marks a user as inactive in a room
-// func (s *ValkeyChatStore) SetUserInactive(userID, roomID string) error {
-// 	roomActiveKey := "chat:room:" + roomID + ":active"
-// 	return s.Client.SRem(s.Ctx, roomActiveKey, userID).Err()
-// }
-
-// // GetActiveUsers gets all active users in a room
-// func (s *ValkeyChatStore) GetActiveUsers(roomID string) ([]string, error) {
-// 	roomActiveKey := "chat:room:" + roomID + ":active"
-// 	return s.Client.SMembers(s.Ctx, roomActiveKey).Result()
-// }
-
 // // GetAllRoomIDs retrieves all room IDs from storage
 // func (s *ValkeyChatStore) GetAllRoomIDs() ([]string, error) {
 // 	// Use pattern matching to find all room keys
@@ -253,3 +234,12 @@ func (s *ValkeyChatStore) GetUserUUIDByEmail(email string) (string, error) {
 
 // 	return rooms, nil
 // }
+
+// UserExists checks if a user profile is stored for the UUID
+func (s *ValkeyChatStore) UserExists(userUUID string) (bool, error) {
+	count, err := s.Client.Exists(s.Ctx, "user:"+userUUID).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A room's active members are a sorted set of "<user>|<node>" entries scored by when each
+// one lapses, so the entries of a crashed node stop counting once their node stops
+// refreshing them. Each node also keeps the list of its own entries, to clear them on startup.
+
+func activeKey(roomID string) string {
+	return roomKey(roomID) + ":active"
+}
+
+func nodeActiveKey(nodeID string) string {
+	return "chat:node:" + nodeID + ":active"
+}
+
+func activeEntry(userID, nodeID string) string {
+	return userID + "|" + nodeID
+}
+
+// nodeEntry is how a node lists one of its entries, room IDs may hold a "|"
+func nodeEntry(roomID, userID string) string {
+	return roomID + "\x00" + userID
+}
+
+func activeUser(entry string) string {
+	userID, _, _ := strings.Cut(entry, "|")
+	return userID
+}
+
+func nowScore() string {
+	return strconv.FormatInt(time.Now().Unix(), 10)
+}
+
+// admitActiveScript drops the lapsed entries of the active set in KEYS[1], then adds the entry
+// ARGV[1] of user ARGV[2] unless the set holds as many users as the active_limit of the room
+// hash in KEYS[2]. The entry is listed in the node's set in KEYS[3] as ARGV[5]. ARGV[3] is now
+// and ARGV[4] when the entry lapses. It returns 1 when the user is active.
+var admitActiveScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[3])
+
+local limit = tonumber(redis.call("HGET", KEYS[2], "active_limit")) or 0
+if limit > 0 then
+	local users = {}
+	local count = 0
+	for _, entry in ipairs(redis.call("ZRANGE", KEYS[1], 0, -1)) do
+		local user = string.sub(entry, 1, (string.find(entry, "|", 1, true) or 0) - 1)
+		if user == ARGV[2] then
+			count = -1
+			break
+		end
+		if not users[user] then
+			users[user] = true
+			count = count + 1
+		end
+	end
+	if count >= limit then
+		return 0
+	end
+end
+
+redis.call("ZADD", KEYS[1], ARGV[4], ARGV[1])
+redis.call("SADD", KEYS[3], ARGV[5])
+return 1
+`)
+
+// AdmitActiveMember marks the user active in the room on the node unless the room has reached
+// its active_limit, it reports whether the user is active. The entry lapses after ttl.
+func (s *ValkeyChatStore) AdmitActiveMember(roomID, userID, nodeID string, ttl time.Duration) (bool, error) {
+	keys := []string{activeKey(roomID), roomKey(roomID), nodeActiveKey(nodeID)}
+	lapse := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	admitted, err := admitActiveScript.Run(s.Ctx, s.Client, keys,
+		activeEntry(userID, nodeID), userID, nowScore(), lapse, nodeEntry(roomID, userID)).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to admit user: %w", err)
+	}
+
+	return admitted == 1, nil
+}
+
+// SetUserActive marks a user as active in a room on the node until ttl passes without a refresh
+func (s *ValkeyChatStore) SetUserActive(roomID, userID, nodeID string, ttl time.Duration) error {
+	pipe := s.Client.Pipeline()
+	pipe.ZAdd(s.Ctx, activeKey(roomID), redis.Z{
+		Score:  float64(time.Now().Add(ttl).Unix()),
+		Member: activeEntry(userID, nodeID),
+	})
+	pipe.SAdd(s.Ctx, nodeActiveKey(nodeID), nodeEntry(roomID, userID))
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to mark user active: %w", err)
+	}
+
+	return nil
+}
+
+// SetUserInactive marks a user as inactive in a room on the node
+func (s *ValkeyChatStore) SetUserInactive(roomID, userID, nodeID string) error {
+	pipe := s.Client.Pipeline()
+	pipe.ZRem(s.Ctx, activeKey(roomID), activeEntry(userID, nodeID))
+	pipe.SRem(s.Ctx, nodeActiveKey(nodeID), nodeEntry(roomID, userID))
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to mark user inactive: %w", err)
+	}
+
+	return nil
+}
+
+// RefreshActiveUsers keeps the node's active users of each room counted for another ttl
+func (s *ValkeyChatStore) RefreshActiveUsers(nodeID string, active map[string][]string, ttl time.Duration) error {
+	if len(active) == 0 {
+		return nil
+	}
+
+	lapse := float64(time.Now().Add(ttl).Unix())
+	pipe := s.Client.Pipeline()
+	for roomID, userIDs := range active {
+		members := make([]redis.Z, len(userIDs))
+		for i, userID := range userIDs {
+			members[i] = redis.Z{Score: lapse, Member: activeEntry(userID, nodeID)}
+		}
+		pipe.ZAddXX(s.Ctx, activeKey(roomID), members...)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to refresh active users: %w", err)
+	}
+
+	return nil
+}
+
+// GetActiveUsers gets all active users in a room, on every node
+func (s *ValkeyChatStore) GetActiveUsers(roomID string) ([]string, error) {
+	entries, err := s.Client.ZRangeByScore(s.Ctx, activeKey(roomID), &redis.ZRangeBy{
+		Min: "(" + nowScore(),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(entries))
+	userIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if userID := activeUser(entry); !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	return userIDs, nil
+}
+
+// ClearNodeActive removes every active entry the node left behind, it runs when the node starts
+func (s *ValkeyChatStore) ClearNodeActive(nodeID string) error {
+	entries, err := s.Client.SMembers(s.Ctx, nodeActiveKey(nodeID)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get node entries: %w", err)
+	}
+
+	pipe := s.Client.Pipeline()
+	for _, entry := range entries {
+		roomID, userID, _ := strings.Cut(entry, "\x00")
+		pipe.ZRem(s.Ctx, activeKey(roomID), activeEntry(userID, nodeID))
+	}
+	pipe.Del(s.Ctx, nodeActiveKey(nodeID))
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to clear node entries: %w", err)
+	}
+
+	return nil
+}
+
+// clearActiveUser removes the user's active entries in the room, on every node
+func (s *ValkeyChatStore) clearActiveUser(roomID, userID string) error {
+	entries, err := s.Client.ZRange(s.Ctx, activeKey(roomID), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if activeUser(entry) == userID {
+			if err := s.Client.ZRem(s.Ctx, activeKey(roomID), entry).Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DropLegacyActiveSets deletes active sets stored as plain sets of user IDs before entries lapsed
+func (s *ValkeyChatStore) DropLegacyActiveSets() error {
+	iter := s.Client.Scan(s.Ctx, 0, "chat:room:*:active", 500).Iterator()
+	for iter.Next(s.Ctx) {
+		keyType, err := s.Client.Type(s.Ctx, iter.Val()).Result()
+		if err != nil {
+			return err
+		}
+		if keyType == "set" {
+			if err := s.Client.Del(s.Ctx, iter.Val()).Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return iter.Err()
+}
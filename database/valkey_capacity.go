@@ -0,0 +1,72 @@
+package db
+
+import (
+	"fmt"
+	"raychat/models"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// admitScript adds ARGV[1] to the set in KEYS[1] unless it already holds as many members as
+// the limit in field ARGV[2] of the room hash in KEYS[2]. It returns 1 when the user was added,
+// 2 when they were in the set already and 0 when the set is full.
+// Active members are admitted by admitActiveScript, see valkey_active.go
+var admitScript = redis.NewScript(`
+if redis.call("SISMEMBER", KEYS[1], ARGV[1]) == 1 then
+	return 2
+end
+
+local limit = tonumber(redis.call("HGET", KEYS[2], ARGV[2])) or 0
+if limit > 0 and redis.call("SCARD", KEYS[1]) >= limit then
+	return 0
+end
+
+redis.call("SADD", KEYS[1], ARGV[1])
+return 1
+`)
+
+func (s *ValkeyChatStore) admit(roomID, set, limitField, userID string) (admitted, added bool, err error) {
+	key := roomKey(roomID)
+
+	result, err := admitScript.Run(s.Ctx, s.Client, []string{key + ":" + set, key}, userID, limitField).Int()
+	if err != nil {
+		return false, false, fmt.Errorf("failed to admit user: %w", err)
+	}
+
+	return result != 0, result == 1, nil
+}
+
+// AdmitRoomMember authorizes the user for the room unless it has reached its count_limit,
+// it reports whether the user is authorized and whether this call added them
+func (s *ValkeyChatStore) AdmitRoomMember(roomID, userID string) (admitted, added bool, err error) {
+	return s.admit(roomID, "auth", "count_limit", userID)
+}
+
+// GetRoomLimits returns the room's capacity limits
+func (s *ValkeyChatStore) GetRoomLimits(roomID string) (models.RoomLimits, error) {
+	fields, err := s.Client.HMGet(s.Ctx, roomKey(roomID), "count_limit", "active_limit").Result()
+	if err != nil {
+		return models.RoomLimits{}, fmt.Errorf("failed to get room limits: %w", err)
+	}
+
+	var limits models.RoomLimits
+	if countLimit, ok := fields[0].(string); ok {
+		limits.CountLimit, _ = strconv.Atoi(countLimit)
+	}
+	if activeLimit, ok := fields[1].(string); ok {
+		limits.ActiveLimit, _ = strconv.Atoi(activeLimit)
+	}
+
+	return limits, nil
+}
+
+// SetRoomLimits replaces the room's capacity limits
+func (s *ValkeyChatStore) SetRoomLimits(roomID string, limits models.RoomLimits) error {
+	err := s.Client.HSet(s.Ctx, roomKey(roomID), "count_limit", limits.CountLimit, "active_limit", limits.ActiveLimit).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set room limits: %w", err)
+	}
+
+	return nil
+}
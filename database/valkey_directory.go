@@ -0,0 +1,137 @@
+package db
+
+import (
+	"fmt"
+	"raychat/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The directory indexes public rooms in sorted sets with equal scores, so they are ordered
+// by their entries, the lowercased name then the room ID, and can be paged through with ZRANGEBYLEX
+const publicRoomsKey = "chat:rooms:public"
+
+func publicRoomsByTypeKey(roomType string) string {
+	return publicRoomsKey + ":" + roomType
+}
+
+func directoryEntry(roomID, name string) string {
+	return strings.ToLower(name) + "\x00" + roomID
+}
+
+// IndexPublicRoom lists the room in the directory, indexing it again is harmless
+func (s *ValkeyChatStore) IndexPublicRoom(roomID, name, roomType string) error {
+	entry := redis.Z{Score: 0, Member: directoryEntry(roomID, name)}
+
+	pipe := s.Client.Pipeline()
+	pipe.ZAdd(s.Ctx, publicRoomsKey, entry)
+	if roomType != "" {
+		pipe.ZAdd(s.Ctx, publicRoomsByTypeKey(roomType), entry)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to index room: %w", err)
+	}
+
+	return nil
+}
+
+// UnindexPublicRoom removes the room from the directory
+func (s *ValkeyChatStore) UnindexPublicRoom(roomID, name, roomType string) error {
+	entry := directoryEntry(roomID, name)
+
+	pipe := s.Client.Pipeline()
+	pipe.ZRem(s.Ctx, publicRoomsKey, entry)
+	if roomType != "" {
+		pipe.ZRem(s.Ctx, publicRoomsByTypeKey(roomType), entry)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to unindex room: %w", err)
+	}
+
+	return nil
+}
+
+// ScanPublicRooms returns up to count directory entries after the given one, an empty
+// after starts from the first room. Only rooms of roomType are listed unless it is empty
+func (s *ValkeyChatStore) ScanPublicRooms(roomType, after string, count int) ([]string, error) {
+	key := publicRoomsKey
+	if roomType != "" {
+		key = publicRoomsByTypeKey(roomType)
+	}
+
+	min := "-"
+	if after != "" {
+		min = "(" + after
+	}
+
+	entries, err := s.Client.ZRangeByLex(s.Ctx, key, &redis.ZRangeBy{Min: min, Max: "+", Count: int64(count)}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan directory: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DirectoryRoomID returns the room ID of a directory entry
+func DirectoryRoomID(entry string) string {
+	if i := strings.LastIndexByte(entry, 0); i >= 0 {
+		return entry[i+1:]
+	}
+	return entry
+}
+
+// GetDirectoryRooms reads the listing of each room, rooms that no longer exist are left out
+func (s *ValkeyChatStore) GetDirectoryRooms(roomIDs []string) ([]models.DirectoryRoom, error) {
+	type roomReads struct {
+		data   *redis.SliceCmd
+		auth   *redis.IntCmd
+		active *redis.IntCmd
+	}
+
+	pipe := s.Client.Pipeline()
+	reads := make([]roomReads, len(roomIDs))
+	for i, roomID := range roomIDs {
+		key := "chat:room:" + roomID
+		reads[i] = roomReads{
+			data:   pipe.HMGet(s.Ctx, key, "name", "description", "room_type", "archived", "created_at"),
+			auth:   pipe.SCard(s.Ctx, key+":auth"),
+			active: pipe.ZCount(s.Ctx, key+":active", "("+nowScore(), "+inf"),
+		}
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to read rooms: %w", err)
+	}
+
+	rooms := make([]models.DirectoryRoom, 0, len(roomIDs))
+	for i, roomID := range roomIDs {
+		fields := reads[i].data.Val()
+		name, ok := fields[0].(string)
+		if !ok {
+			continue
+		}
+
+		room := models.DirectoryRoom{
+			ID:          roomID,
+			Name:        name,
+			MemberCount: reads[i].auth.Val(),
+			ActiveCount: reads[i].active.Val(),
+		}
+		room.Description, _ = fields[1].(string)
+		room.RoomType, _ = fields[2].(string)
+		if archived, ok := fields[3].(string); ok {
+			room.Archived, _ = strconv.ParseBool(archived)
+		}
+		if createdAt, ok := fields[4].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339, createdAt); err == nil {
+				room.CreatedAt = parsed.Unix()
+			}
+		}
+
+		rooms = append(rooms, room)
+	}
+
+	return rooms, nil
+}
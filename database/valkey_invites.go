@@ -0,0 +1,143 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"raychat/models"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Errors of RedeemInvite
+var (
+	ErrInviteNotFound = fmt.Errorf("invite not found")
+	ErrInviteUsedUp   = fmt.Errorf("invite has no uses left")
+)
+
+func inviteKey(token string) string {
+	return "chat:invite:" + token
+}
+
+func roomInvitesKey(roomID string) string {
+	return "chat:room:" + roomID + ":invites"
+}
+
+// redeemInviteScript counts a use of the invite in KEYS[1], the invite is removed from the
+// room's set in KEYS[2] once its last use is taken. It returns the uses so far, -1 if the
+// invite or its room hash in KEYS[3] doesn't exist and -2 if it has no uses left.
+var redeemInviteScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 or redis.call("EXISTS", KEYS[3]) == 0 then
+	return -1
+end
+
+local maxUses = tonumber(redis.call("HGET", KEYS[1], "max_uses")) or 0
+local uses = tonumber(redis.call("HGET", KEYS[1], "uses")) or 0
+if maxUses > 0 and uses >= maxUses then
+	return -2
+end
+
+uses = redis.call("HINCRBY", KEYS[1], "uses", 1)
+if maxUses > 0 and uses >= maxUses then
+	redis.call("DEL", KEYS[1])
+	redis.call("SREM", KEYS[2], ARGV[1])
+end
+
+return uses
+`)
+
+// CreateInvite stores an invite until it expires
+func (s *ValkeyChatStore) CreateInvite(invite models.Invite) error {
+	data, err := json.Marshal(invite)
+	if err != nil {
+		return fmt.Errorf("failed to marshal invite: %w", err)
+	}
+
+	key := inviteKey(invite.Token)
+	pipe := s.Client.TxPipeline()
+	pipe.HSet(s.Ctx, key, "invite", data, "max_uses", invite.MaxUses, "uses", 0)
+	pipe.ExpireAt(s.Ctx, key, time.Unix(invite.ExpiresAt, 0))
+	pipe.SAdd(s.Ctx, roomInvitesKey(invite.RoomID), invite.Token)
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to store invite: %w", err)
+	}
+
+	return nil
+}
+
+// GetInvite returns an unexpired invite with its current number of uses
+func (s *ValkeyChatStore) GetInvite(token string) (*models.Invite, error) {
+	fields, err := s.Client.HMGet(s.Ctx, inviteKey(token), "invite", "uses").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invite: %w", err)
+	}
+
+	data, ok := fields[0].(string)
+	if !ok {
+		return nil, ErrInviteNotFound
+	}
+
+	var invite models.Invite
+	if err := json.Unmarshal([]byte(data), &invite); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal invite: %w", err)
+	}
+	if uses, ok := fields[1].(string); ok {
+		invite.Uses, _ = strconv.Atoi(uses)
+	}
+
+	return &invite, nil
+}
+
+// GetRoomInvites returns the room's unexpired invites, expired ones are dropped from the room's set
+func (s *ValkeyChatStore) GetRoomInvites(roomID string) ([]models.Invite, error) {
+	tokens, err := s.Client.SMembers(s.Ctx, roomInvitesKey(roomID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invites: %w", err)
+	}
+
+	invites := make([]models.Invite, 0, len(tokens))
+	for _, token := range tokens {
+		invite, err := s.GetInvite(token)
+		if err == ErrInviteNotFound {
+			s.Client.SRem(s.Ctx, roomInvitesKey(roomID), token)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		invites = append(invites, *invite)
+	}
+
+	return invites, nil
+}
+
+// RevokeInvite deletes the room's invite
+func (s *ValkeyChatStore) RevokeInvite(roomID, token string) error {
+	pipe := s.Client.TxPipeline()
+	pipe.Del(s.Ctx, inviteKey(token))
+	pipe.SRem(s.Ctx, roomInvitesKey(roomID), token)
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to revoke invite: %w", err)
+	}
+
+	return nil
+}
+
+// RedeemInvite takes a use of the invite, it fails with ErrInviteNotFound, also once the room
+// was deleted, or ErrInviteUsedUp
+func (s *ValkeyChatStore) RedeemInvite(roomID, token string) error {
+	keys := []string{inviteKey(token), roomInvitesKey(roomID), roomKey(roomID)}
+	uses, err := redeemInviteScript.Run(s.Ctx, s.Client, keys, token).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to redeem invite: %w", err)
+	}
+
+	switch uses {
+	case -1:
+		return ErrInviteNotFound
+	case -2:
+		return ErrInviteUsedUp
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"raychat/models"
+	"sort"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func joinRequestsKey(roomID string) string {
+	return "chat:room:" + roomID + ":join_requests"
+}
+
+// SaveJoinRequest stores a user's request to join the room, replacing an earlier one
+func (s *ValkeyChatStore) SaveJoinRequest(request models.MembershipRequest) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal join request: %w", err)
+	}
+
+	if err := s.Client.HSet(s.Ctx, joinRequestsKey(request.RoomID), request.UserID, data).Err(); err != nil {
+		return fmt.Errorf("failed to store join request: %w", err)
+	}
+
+	return nil
+}
+
+// GetJoinRequest returns the user's pending request to join the room, nil if there is none
+func (s *ValkeyChatStore) GetJoinRequest(roomID, userID string) (*models.MembershipRequest, error) {
+	data, err := s.Client.HGet(s.Ctx, joinRequestsKey(roomID), userID).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get join request: %w", err)
+	}
+
+	var request models.MembershipRequest
+	if err := json.Unmarshal([]byte(data), &request); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal join request: %w", err)
+	}
+
+	return &request, nil
+}
+
+// GetJoinRequests returns the room's pending join requests, oldest first
+func (s *ValkeyChatStore) GetJoinRequests(roomID string) ([]models.MembershipRequest, error) {
+	entries, err := s.Client.HGetAll(s.Ctx, joinRequestsKey(roomID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get join requests: %w", err)
+	}
+
+	requests := make([]models.MembershipRequest, 0, len(entries))
+	for _, data := range entries {
+		var request models.MembershipRequest
+		if err := json.Unmarshal([]byte(data), &request); err != nil {
+			continue
+		}
+		requests = append(requests, request)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt < requests[j].CreatedAt
+	})
+
+	return requests, nil
+}
+
+// DeleteJoinRequest removes the user's pending request, it reports whether there was one
+func (s *ValkeyChatStore) DeleteJoinRequest(roomID, userID string) (bool, error) {
+	removed, err := s.Client.HDel(s.Ctx, joinRequestsKey(roomID), userID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete join request: %w", err)
+	}
+
+	return removed > 0, nil
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"raychat/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Older mentions are dropped once a user's inbox holds this many
+const maxMentionInbox = 500
+
+func mentionsKey(userID string) string {
+	return "chat:user:" + userID + ":mentions"
+}
+
+// AddMentions stores each user's mention at the top of their inbox, in one round trip
+func (s *ValkeyChatStore) AddMentions(mentions map[string]models.Mention) error {
+	if len(mentions) == 0 {
+		return nil
+	}
+
+	pipe := s.Client.Pipeline()
+	for userID, mention := range mentions {
+		data, err := json.Marshal(mention)
+		if err != nil {
+			return fmt.Errorf("failed to marshal mention: %w", err)
+		}
+
+		pipe.LPush(s.Ctx, mentionsKey(userID), data)
+		pipe.LTrim(s.Ctx, mentionsKey(userID), 0, maxMentionInbox-1)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to store mentions: %w", err)
+	}
+
+	return nil
+}
+
+// GetMentions returns up to limit mentions from the user's inbox, newest first
+func (s *ValkeyChatStore) GetMentions(userID string, limit int) ([]models.Mention, error) {
+	entries, err := s.Client.LRange(s.Ctx, mentionsKey(userID), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mentions: %w", err)
+	}
+
+	mentions := make([]models.Mention, 0, len(entries))
+	for _, entry := range entries {
+		var mention models.Mention
+		if err := json.Unmarshal([]byte(entry), &mention); err != nil {
+			continue
+		}
+		mentions = append(mentions, mention)
+	}
+
+	return mentions, nil
+}
+
+// ClearMentions empties the user's inbox, or only removes the mentions of one message when messageID is set
+func (s *ValkeyChatStore) ClearMentions(userID, messageID string) error {
+	if messageID == "" {
+		return s.Client.Del(s.Ctx, mentionsKey(userID)).Err()
+	}
+
+	entries, err := s.Client.LRange(s.Ctx, mentionsKey(userID), 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get mentions: %w", err)
+	}
+
+	for _, entry := range entries {
+		var mention models.Mention
+		if err := json.Unmarshal([]byte(entry), &mention); err != nil || mention.MessageID != messageID {
+			continue
+		}
+		if err := s.Client.LRem(s.Ctx, mentionsKey(userID), 0, entry).Err(); err != nil {
+			return fmt.Errorf("failed to clear mention: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// DropMentions removes the mentions of the given messages from the user's inbox
+func (s *ValkeyChatStore) DropMentions(userID string, messageIDs map[string]bool) error {
+	entries, err := s.Client.LRange(s.Ctx, mentionsKey(userID), 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get mentions: %w", err)
+	}
+
+	pipe := s.Client.Pipeline()
+	for _, entry := range entries {
+		var mention models.Mention
+		if err := json.Unmarshal([]byte(entry), &mention); err != nil || !messageIDs[mention.MessageID] {
+			continue
+		}
+		pipe.LRem(s.Ctx, mentionsKey(userID), 0, entry)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to drop mentions: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserNames returns the names of the given users in two round trips, whatever their number
+// Users without a name are left out
+func (s *ValkeyChatStore) GetUserNames(userUUIDs []string) (map[string]string, error) {
+	names := make(map[string]string, len(userUUIDs))
+	if len(userUUIDs) == 0 {
+		return names, nil
+	}
+
+	pipe := s.Client.Pipeline()
+	types := make([]*redis.StatusCmd, len(userUUIDs))
+	for i, userUUID := range userUUIDs {
+		types[i] = pipe.Type(s.Ctx, "user:"+userUUID)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return nil, fmt.Errorf("failed to get user types: %w", err)
+	}
+
+	// App users keep their name in a hash field, CLI users in a JSON string
+	pipe = s.Client.Pipeline()
+	values := make(map[string]*redis.StringCmd, len(userUUIDs))
+	for i, userUUID := range userUUIDs {
+		switch types[i].Val() {
+		case "hash":
+			values[userUUID] = pipe.HGet(s.Ctx, "user:"+userUUID, "name")
+		case "string":
+			values[userUUID] = pipe.Get(s.Ctx, "user:"+userUUID)
+		}
+	}
+	if len(values) == 0 {
+		return names, nil
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get user names: %w", err)
+	}
+
+	for i, userUUID := range userUUIDs {
+		value, exists := values[userUUID]
+		if !exists || value.Err() != nil {
+			continue
+		}
+
+		if types[i].Val() == "hash" {
+			names[userUUID] = value.Val()
+			continue
+		}
+
+		var user models.UserCred
+		if err := json.Unmarshal([]byte(value.Val()), &user); err == nil {
+			names[userUUID] = user.Username
+		}
+	}
+
+	return names, nil
+}
+
+// GetUserName returns the user's name, whether they signed up through the app or the CLI
+func (s *ValkeyChatStore) GetUserName(userUUID string) (string, error) {
+	userKey := "user:" + userUUID
+
+	keyType, err := s.Client.Type(s.Ctx, userKey).Result()
+	if err != nil {
+		return "", err
+	}
+
+	switch keyType {
+	case "hash":
+		return s.Client.HGet(s.Ctx, userKey, "name").Result()
+	case "string":
+		user, err := s.GetUserByUUIDCLI(userUUID)
+		if err != nil {
+			return "", err
+		}
+		return user.Username, nil
+	}
+
+	return "", fmt.Errorf("user not found")
+}
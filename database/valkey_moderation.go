@@ -0,0 +1,109 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"raychat/models"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Kinds of room restrictions, each kept in a sorted set scored by expiry
+const (
+	RestrictionMute = "mutes"
+	RestrictionBan  = "bans"
+	RestrictionKick = "kicks"
+)
+
+func restrictionKey(roomID, kind string) string {
+	return "chat:room:" + roomID + ":" + kind
+}
+
+func moderationKey(roomID string) string {
+	return "chat:room:" + roomID + ":moderation"
+}
+
+// SetRoomRestriction restricts the user in the room until the moderation expires
+func (s *ValkeyChatStore) SetRoomRestriction(kind string, moderation models.Moderation) error {
+	data, err := json.Marshal(moderation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal moderation: %w", err)
+	}
+
+	expiresAt := math.Inf(1)
+	if moderation.ExpiresAt != 0 {
+		expiresAt = float64(moderation.ExpiresAt)
+	}
+
+	pipe := s.Client.TxPipeline()
+	pipe.ZAdd(s.Ctx, restrictionKey(moderation.RoomID, kind), redis.Z{Score: expiresAt, Member: moderation.UserID})
+	pipe.HSet(s.Ctx, moderationKey(moderation.RoomID), kind+":"+moderation.UserID, data)
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to store restriction: %w", err)
+	}
+
+	return nil
+}
+
+// ClearRoomRestriction lifts the user's restriction in the room
+func (s *ValkeyChatStore) ClearRoomRestriction(roomID, kind, userID string) error {
+	pipe := s.Client.TxPipeline()
+	pipe.ZRem(s.Ctx, restrictionKey(roomID, kind), userID)
+	pipe.HDel(s.Ctx, moderationKey(roomID), kind+":"+userID)
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to clear restriction: %w", err)
+	}
+
+	return nil
+}
+
+// IsRoomRestricted reports whether the user has an unexpired restriction of this kind in the room
+func (s *ValkeyChatStore) IsRoomRestricted(roomID, kind, userID string) (bool, error) {
+	expiresAt, err := s.Client.ZScore(s.Ctx, restrictionKey(roomID, kind), userID).Result()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check restriction: %w", err)
+	}
+
+	return expiresAt > float64(time.Now().Unix()), nil
+}
+
+// GetRoomRestrictions returns the unexpired restrictions of this kind in the room, expired ones are dropped
+func (s *ValkeyChatStore) GetRoomRestrictions(roomID, kind string) ([]models.Moderation, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	expired, err := s.Client.ZRangeByScore(s.Ctx, restrictionKey(roomID, kind), &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expired restrictions: %w", err)
+	}
+	for _, userID := range expired {
+		if err := s.ClearRoomRestriction(roomID, kind, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	userIDs, err := s.Client.ZRangeByScore(s.Ctx, restrictionKey(roomID, kind), &redis.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get restrictions: %w", err)
+	}
+
+	moderations := make([]models.Moderation, 0, len(userIDs))
+	for _, userID := range userIDs {
+		data, err := s.Client.HGet(s.Ctx, moderationKey(roomID), kind+":"+userID).Result()
+		if err != nil {
+			continue
+		}
+
+		var moderation models.Moderation
+		if err := json.Unmarshal([]byte(data), &moderation); err != nil {
+			continue
+		}
+		moderations = append(moderations, moderation)
+	}
+
+	return moderations, nil
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"fmt"
+	"raychat/models"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func presenceKey(userID string) string {
+	return "presence:" + userID
+}
+
+func connectionsKey(userID string) string {
+	return "presence:" + userID + ":connections"
+}
+
+// Users with a connection on any node, scored by when their latest one lapses
+const onlineUsersKey = "presence:online"
+
+// AddUserConnection counts a connection of the user until ttl passes without a refresh
+// The connections are a sorted set scored by when each one lapses, so a crashed node's lapse on their own
+func (s *ValkeyChatStore) AddUserConnection(userID, connectionID string, ttl time.Duration) error {
+	return s.RefreshUserConnections(userID, []string{connectionID}, ttl)
+}
+
+// RefreshUserConnections keeps the user's connections counted for another ttl
+func (s *ValkeyChatStore) RefreshUserConnections(userID string, connectionIDs []string, ttl time.Duration) error {
+	lapse := float64(time.Now().Add(ttl).Unix())
+	members := make([]redis.Z, len(connectionIDs))
+	for i, connectionID := range connectionIDs {
+		members[i] = redis.Z{Score: lapse, Member: connectionID}
+	}
+
+	pipe := s.Client.Pipeline()
+	pipe.ZAdd(s.Ctx, connectionsKey(userID), members...)
+	pipe.Expire(s.Ctx, connectionsKey(userID), ttl)
+	pipe.ZAddGT(s.Ctx, onlineUsersKey, redis.Z{Score: lapse, Member: userID})
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to store connection: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveUserConnection stops counting a connection of the user, it returns how many are left on any node
+func (s *ValkeyChatStore) RemoveUserConnection(userID, connectionID string) (int64, error) {
+	key := connectionsKey(userID)
+
+	pipe := s.Client.TxPipeline()
+	pipe.ZRem(s.Ctx, key, connectionID)
+	pipe.ZRemRangeByScore(s.Ctx, key, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+	left := pipe.ZCard(s.Ctx, key)
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return 0, fmt.Errorf("failed to remove connection: %w", err)
+	}
+
+	if left.Val() == 0 {
+		if err := s.Client.ZRem(s.Ctx, onlineUsersKey, userID).Err(); err != nil {
+			return 0, fmt.Errorf("failed to remove online user: %w", err)
+		}
+	}
+
+	return left.Val(), nil
+}
+
+// GetOnlineUsers returns up to limit users with a live connection on any node
+// Users of a crashed node are left out once their connections lapse
+func (s *ValkeyChatStore) GetOnlineUsers(limit int64) ([]string, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	pipe := s.Client.Pipeline()
+	pipe.ZRemRangeByScore(s.Ctx, onlineUsersKey, "-inf", "("+now)
+	users := pipe.ZRangeByScore(s.Ctx, onlineUsersKey, &redis.ZRangeBy{Min: now, Max: "+inf", Count: limit})
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return nil, fmt.Errorf("failed to get online users: %w", err)
+	}
+
+	return users.Val(), nil
+}
+
+// CountUserConnections returns how many of the user's connections are live on any node
+func (s *ValkeyChatStore) CountUserConnections(userID string) (int64, error) {
+	count, err := s.Client.ZCount(s.Ctx, connectionsKey(userID), strconv.FormatInt(time.Now().Unix(), 10), "+inf").Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count connections: %w", err)
+	}
+
+	return count, nil
+}
+
+// SetUserPresence stores the user's presence status and last-seen time
+// The status is mirrored on the user profile hash when the user has one
+func (s *ValkeyChatStore) SetUserPresence(userID, status string, lastSeen time.Time) error {
+	err := s.Client.HSet(s.Ctx, presenceKey(userID),
+		"status", status,
+		"last_seen", lastSeen.Unix(),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store presence: %w", err)
+	}
+
+	return s.updateUserProfile(userID, "status", status)
+}
+
+// TouchUserLastSeen refreshes the user's last-seen time without changing the status
+func (s *ValkeyChatStore) TouchUserLastSeen(userID string, lastSeen time.Time) error {
+	return s.Client.HSet(s.Ctx, presenceKey(userID), "last_seen", lastSeen.Unix()).Err()
+}
+
+// RecordUserLogin stores the time the user connected as the profile's last login
+func (s *ValkeyChatStore) RecordUserLogin(userID string, loginAt time.Time) error {
+	return s.updateUserProfile(userID, "last_login", loginAt.Format(time.RFC3339))
+}
+
+// updateUserProfile sets a field of the user profile hash
+// CLI users are stored as a JSON string instead of a hash, those are left untouched
+func (s *ValkeyChatStore) updateUserProfile(userID, field, value string) error {
+	userKey := "user:" + userID
+
+	keyType, err := s.Client.Type(s.Ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+	if keyType != "hash" {
+		return nil
+	}
+
+	return s.Client.HSet(s.Ctx, userKey, field, value).Err()
+}
+
+// GetUsersPresence returns the stored presence of each user, users never seen are offline
+func (s *ValkeyChatStore) GetUsersPresence(userIDs []string) ([]models.Presence, error) {
+	pipe := s.Client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.HGetAll(s.Ctx, presenceKey(userID))
+	}
+
+	if _, err := pipe.Exec(s.Ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get presence: %w", err)
+	}
+
+	presences := make([]models.Presence, 0, len(userIDs))
+	for i, userID := range userIDs {
+		presence := models.Presence{UserID: userID, Status: "offline"}
+
+		data := cmds[i].Val()
+		if status, exists := data["status"]; exists {
+			presence.Status = status
+		}
+		if lastSeen, err := strconv.ParseInt(data["last_seen"], 10, 64); err == nil {
+			presence.LastSeen = lastSeen
+		}
+
+		presences = append(presences, presence)
+	}
+
+	return presences, nil
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"raychat/models"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Oldest queued messages are trimmed once a user's queue holds about this many
+	maxQueuedMessages = 1000
+
+	// A queue nobody connected to read is dropped after this long
+	queueTTL = 7 * 24 * time.Hour
+)
+
+func queueKey(userID string) string {
+	return "chat:user:" + userID + ":queue"
+}
+
+// QueuedMessage is a message waiting in a user's offline queue
+type QueuedMessage struct {
+	StreamID string
+	Message  *models.Message
+}
+
+// QueueMessage appends the message to the offline queue of every given user
+func (s *ValkeyChatStore) QueueMessage(userIDs []string, msg *models.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	pipe := s.Client.Pipeline()
+	for _, userID := range userIDs {
+		pipe.XAdd(s.Ctx, &redis.XAddArgs{
+			Stream: queueKey(userID),
+			MaxLen: maxQueuedMessages,
+			Approx: true,
+			Values: map[string]interface{}{"message": data},
+		})
+		pipe.Expire(s.Ctx, queueKey(userID), queueTTL)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to queue message: %w", err)
+	}
+
+	return nil
+}
+
+// GetQueuedMessages returns up to count of the oldest messages in the user's offline queue
+func (s *ValkeyChatStore) GetQueuedMessages(userID string, count int64) ([]QueuedMessage, error) {
+	entries, err := s.Client.XRangeN(s.Ctx, queueKey(userID), "-", "+", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	queued := make([]QueuedMessage, 0, len(entries))
+	for _, entry := range entries {
+		data, _ := entry.Values["message"].(string)
+
+		msg := &models.Message{}
+		if err := json.Unmarshal([]byte(data), msg); err != nil {
+			msg = nil // Still returned so the caller can drop it from the queue
+		}
+		queued = append(queued, QueuedMessage{StreamID: entry.ID, Message: msg})
+	}
+
+	return queued, nil
+}
+
+// AckQueuedMessages removes delivered messages from the user's offline queue
+func (s *ValkeyChatStore) AckQueuedMessages(userID string, streamIDs ...string) error {
+	if len(streamIDs) == 0 {
+		return nil
+	}
+
+	return s.Client.XDel(s.Ctx, queueKey(userID), streamIDs...).Err()
+}
+
+// DropQueuedMessages removes the given messages, and the events targeting them, from the user's offline queue
+func (s *ValkeyChatStore) DropQueuedMessages(userID string, messageIDs map[string]bool) error {
+	return s.dropQueued(userID, func(msg *models.Message) bool {
+		return messageIDs[msg.ID] || messageIDs[msg.TargetID]
+	})
+}
+
+// DropQueuedRoom removes every message of the room from the user's offline queue
+func (s *ValkeyChatStore) DropQueuedRoom(userID, roomID string) error {
+	return s.dropQueued(userID, func(msg *models.Message) bool {
+		return msg.RoomID == roomID
+	})
+}
+
+func (s *ValkeyChatStore) dropQueued(userID string, matches func(*models.Message) bool) error {
+	// Approximate trimming lets a queue run somewhat past maxQueuedMessages
+	queued, err := s.GetQueuedMessages(userID, maxQueuedMessages*2)
+	if err != nil {
+		return err
+	}
+
+	dropped := make([]string, 0)
+	for _, entry := range queued {
+		if entry.Message != nil && matches(entry.Message) {
+			dropped = append(dropped, entry.StreamID)
+		}
+	}
+
+	return s.AckQueuedMessages(userID, dropped...)
+}
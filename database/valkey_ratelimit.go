@@ -0,0 +1,51 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeTokenScript refills a token bucket stored in a hash and takes one token from it.
+// It returns 1 and 0 when a token was taken, or 0 and the milliseconds until the next one.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated_at")
+local tokens = tonumber(bucket[1]) or burst
+local updatedAt = tonumber(bucket[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - updatedAt) * rate / 1000)
+
+local allowed = 0
+local retryAfter = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retryAfter = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated_at", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+
+return {allowed, retryAfter}
+`)
+
+// TakeToken takes a token from the shared bucket under key, refilled at rate tokens per second
+// up to burst. When the bucket is empty it returns false and how long until a token is available.
+func (s *ValkeyChatStore) TakeToken(key string, rate float64, burst int) (bool, time.Duration, error) {
+	result, err := takeTokenScript.Run(s.Ctx, s.Client, []string{key}, rate, burst, time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take token: %w", err)
+	}
+
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected token bucket reply %v", result)
+	}
+
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
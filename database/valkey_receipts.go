@@ -0,0 +1,82 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"raychat/models"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Delivery acks are only kept while clients may still ask about them
+const deliveredTTL = 7 * 24 * time.Hour
+
+func readCursorsKey(roomID string) string {
+	return "chat:room:" + roomID + ":read"
+}
+
+// MarkMessageDelivered records that the message reached the user
+func (s *ValkeyChatStore) MarkMessageDelivered(roomID, messageID, userID string) error {
+	key := fmt.Sprintf("chat:room:%s:delivered:%s", roomID, messageID)
+
+	pipe := s.Client.TxPipeline()
+	pipe.SAdd(s.Ctx, key, userID)
+	pipe.Expire(s.Ctx, key, deliveredTTL)
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to mark message delivered: %w", err)
+	}
+
+	return nil
+}
+
+// SetReadCursor moves the user's read cursor in the room forward
+// It reports false when the stored cursor is already at or past the given one
+func (s *ValkeyChatStore) SetReadCursor(roomID string, cursor models.ReadCursor) (bool, error) {
+	key := readCursorsKey(roomID)
+
+	stored, err := s.Client.HGet(s.Ctx, key, cursor.UserID).Result()
+	if err != nil && err != redis.Nil {
+		return false, fmt.Errorf("failed to get read cursor: %w", err)
+	}
+
+	if err == nil {
+		var current models.ReadCursor
+		if json.Unmarshal([]byte(stored), &current) == nil &&
+			(current.MessageID == cursor.MessageID || current.Timestamp > cursor.Timestamp) {
+			return false, nil
+		}
+	}
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal read cursor: %w", err)
+	}
+
+	if err := s.Client.HSet(s.Ctx, key, cursor.UserID, data).Err(); err != nil {
+		return false, fmt.Errorf("failed to store read cursor: %w", err)
+	}
+
+	return true, nil
+}
+
+// GetReadCursors returns the read cursor of every member who has read something in the room
+func (s *ValkeyChatStore) GetReadCursors(roomID string) ([]models.ReadCursor, error) {
+	key := readCursorsKey(roomID)
+
+	stored, err := s.Client.HGetAll(s.Ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get read cursors: %w", err)
+	}
+
+	cursors := make([]models.ReadCursor, 0, len(stored))
+	for _, data := range stored {
+		var cursor models.ReadCursor
+		if err := json.Unmarshal([]byte(data), &cursor); err != nil {
+			continue
+		}
+		cursors = append(cursors, cursor)
+	}
+
+	return cursors, nil
+}
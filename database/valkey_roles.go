@@ -0,0 +1,76 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+func memberRolesKey(roomID string) string {
+	return "chat:room:" + roomID + ":roles"
+}
+
+func rolePermissionsKey(roomID string) string {
+	return "chat:room:" + roomID + ":role_permissions"
+}
+
+// GetRoomRoles returns the roles assigned to the room's members, by user ID
+func (s *ValkeyChatStore) GetRoomRoles(roomID string) (map[string]string, error) {
+	roles, err := s.Client.HGetAll(s.Ctx, memberRolesKey(roomID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// SetMemberRole assigns a role to a member of the room, keeping the admins set in step
+func (s *ValkeyChatStore) SetMemberRole(roomID, userID, role string, isAdmin bool) error {
+	adminsKey := "chat:room:" + roomID + ":admins"
+
+	pipe := s.Client.TxPipeline()
+	pipe.HSet(s.Ctx, memberRolesKey(roomID), userID, role)
+	if isAdmin {
+		pipe.SAdd(s.Ctx, adminsKey, userID)
+	} else {
+		pipe.SRem(s.Ctx, adminsKey, userID)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to set role: %w", err)
+	}
+
+	return nil
+}
+
+// GetRolePermissions returns the room's customized roles and their permissions
+func (s *ValkeyChatStore) GetRolePermissions(roomID string) (map[string][]string, error) {
+	stored, err := s.Client.HGetAll(s.Ctx, rolePermissionsKey(roomID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role permissions: %w", err)
+	}
+
+	permissions := make(map[string][]string, len(stored))
+	for role, data := range stored {
+		var granted []string
+		if err := json.Unmarshal([]byte(data), &granted); err != nil {
+			continue
+		}
+		permissions[role] = granted
+	}
+
+	return permissions, nil
+}
+
+// SetRolePermissions defines a role of the room, or overrides a built-in one
+func (s *ValkeyChatStore) SetRolePermissions(roomID, role string, permissions []string) error {
+	data, err := json.Marshal(permissions)
+	if err != nil {
+		return fmt.Errorf("failed to marshal permissions: %w", err)
+	}
+
+	return s.Client.HSet(s.Ctx, rolePermissionsKey(roomID), role, data).Err()
+}
+
+// DeleteRolePermissions drops a customized role of the room
+func (s *ValkeyChatStore) DeleteRolePermissions(roomID, role string) error {
+	return s.Client.HDel(s.Ctx, rolePermissionsKey(roomID), role).Err()
+}
@@ -22,9 +22,6 @@ func (store *ValkeyChatStore) StoreRoomInValkey(roomID string, data models.RoomD
 		return err
 	}
 
-	// Set expiration (optional)
-	store.Client.Expire(store.Ctx, roomKey, 24*time.Hour)
-
 	return nil
 }
 
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func roomKey(roomID string) string {
+	return "chat:room:" + roomID
+}
+
+// RoomExists checks if the room's hash is stored
+func (s *ValkeyChatStore) RoomExists(roomID string) (bool, error) {
+	count, err := s.Client.Exists(s.Ctx, roomKey(roomID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// PersistRoom clears the expiry rooms used to be stored with, so they no longer vanish after a day
+func (s *ValkeyChatStore) PersistRoom(roomID string) error {
+	pipe := s.Client.Pipeline()
+	pipe.Persist(s.Ctx, roomKey(roomID))
+	pipe.Persist(s.Ctx, roomKey(roomID)+":auth")
+	pipe.Persist(s.Ctx, roomKey(roomID)+":admins")
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to persist room: %w", err)
+	}
+
+	return nil
+}
+
+// SetRoomArchived archives the room or brings it back
+func (s *ValkeyChatStore) SetRoomArchived(roomID string, archived bool) error {
+	if err := s.Client.HSet(s.Ctx, roomKey(roomID), "archived", archived).Err(); err != nil {
+		return fmt.Errorf("failed to set archived: %w", err)
+	}
+
+	return nil
+}
+
+// TransferRoomOwnership makes the new owner the room's creator, the previous owner stays an admin
+func (s *ValkeyChatStore) TransferRoomOwnership(roomID, previousOwnerID, newOwnerID string) error {
+	key := roomKey(roomID)
+
+	pipe := s.Client.TxPipeline()
+	pipe.HSet(s.Ctx, key, "creator_id", newOwnerID)
+	pipe.SAdd(s.Ctx, key+":admins", previousOwnerID, newOwnerID)
+	pipe.HSet(s.Ctx, memberRolesKey(roomID), previousOwnerID, "admin")
+	pipe.HDel(s.Ctx, memberRolesKey(roomID), newOwnerID)
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to transfer ownership: %w", err)
+	}
+
+	return nil
+}
+
+// roomKeys lists the keys every room may have next to its hash
+func roomKeys(roomID string) []string {
+	return []string{
+		roomKey(roomID),
+		roomKey(roomID) + ":auth",
+		roomKey(roomID) + ":admins",
+		activeKey(roomID),
+		roomInvitesKey(roomID),
+		joinRequestsKey(roomID),
+		moderationKey(roomID),
+		restrictionKey(roomID, RestrictionMute),
+		restrictionKey(roomID, RestrictionKick),
+		restrictionKey(roomID, RestrictionBan),
+		memberRolesKey(roomID),
+		rolePermissionsKey(roomID),
+		readCursorsKey(roomID),
+	}
+}
+
+// DeleteRoom removes the room's keys, its invites and the threads its members follow, and
+// the room from its members' room lists. The keys are listed rather than matched, since a
+// room ID may hold glob characters or be the prefix of another room's ID.
+// Delivery acks of the room's messages lapse on their own.
+func (s *ValkeyChatStore) DeleteRoom(roomID string, members []string) error {
+	keys := roomKeys(roomID)
+
+	tokens, err := s.Client.SMembers(s.Ctx, roomInvitesKey(roomID)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get room invites: %w", err)
+	}
+	for _, token := range tokens {
+		keys = append(keys, inviteKey(token))
+	}
+
+	pipe := s.Client.Pipeline()
+	threads := make([]*redis.StringSliceCmd, len(members))
+	for i, userID := range members {
+		threads[i] = pipe.SMembers(s.Ctx, userThreadsKey(roomID, userID))
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to get room threads: %w", err)
+	}
+	for i, userID := range members {
+		keys = append(keys, userThreadsKey(roomID, userID))
+		for _, parentID := range threads[i].Val() {
+			keys = append(keys, threadSubscribersKey(roomID, parentID))
+		}
+	}
+
+	pipe = s.Client.Pipeline()
+	for start := 0; start < len(keys); start += 500 {
+		end := min(start+500, len(keys))
+		pipe.Unlink(s.Ctx, keys[start:end]...)
+	}
+	for _, userID := range members {
+		pipe.SRem(s.Ctx, "chat:user:"+userID+":rooms", roomID)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to delete room: %w", err)
+	}
+
+	return nil
+}
+
+// SetRoomMessageTTL sets how many seconds the room's messages last, 0 keeps them
+func (s *ValkeyChatStore) SetRoomMessageTTL(roomID string, ttlSeconds int64) error {
+	if err := s.Client.HSet(s.Ctx, roomKey(roomID), "message_ttl", ttlSeconds).Err(); err != nil {
+		return fmt.Errorf("failed to set message ttl: %w", err)
+	}
+
+	return nil
+}
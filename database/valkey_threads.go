@@ -0,0 +1,71 @@
+package db
+
+import "fmt"
+
+func threadSubscribersKey(roomID, parentID string) string {
+	return fmt.Sprintf("chat:room:%s:thread:%s:subscribers", roomID, parentID)
+}
+
+// userThreadsKey indexes the threads of the room a user follows, so they can all be left at once
+func userThreadsKey(roomID, userID string) string {
+	return fmt.Sprintf("chat:room:%s:user:%s:threads", roomID, userID)
+}
+
+// SubscribeToThread adds users to the subscribers of the thread started by the parent message
+func (s *ValkeyChatStore) SubscribeToThread(roomID, parentID string, userIDs ...string) error {
+	members := make([]interface{}, len(userIDs))
+	for i, userID := range userIDs {
+		members[i] = userID
+	}
+
+	pipe := s.Client.Pipeline()
+	pipe.SAdd(s.Ctx, threadSubscribersKey(roomID, parentID), members...)
+	for _, userID := range userIDs {
+		pipe.SAdd(s.Ctx, userThreadsKey(roomID, userID), parentID)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to thread: %w", err)
+	}
+	return nil
+}
+
+// GetThreadSubscribers returns the users following the thread started by the parent message
+func (s *ValkeyChatStore) GetThreadSubscribers(roomID, parentID string) ([]string, error) {
+	return s.Client.SMembers(s.Ctx, threadSubscribersKey(roomID, parentID)).Result()
+}
+
+// UnsubscribeFromThread removes users from the subscribers of the thread started by the parent message
+func (s *ValkeyChatStore) UnsubscribeFromThread(roomID, parentID string, userIDs ...string) error {
+	members := make([]interface{}, len(userIDs))
+	for i, userID := range userIDs {
+		members[i] = userID
+	}
+
+	pipe := s.Client.Pipeline()
+	pipe.SRem(s.Ctx, threadSubscribersKey(roomID, parentID), members...)
+	for _, userID := range userIDs {
+		pipe.SRem(s.Ctx, userThreadsKey(roomID, userID), parentID)
+	}
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to unsubscribe from thread: %w", err)
+	}
+	return nil
+}
+
+// UnsubscribeFromThreads removes the user from the subscribers of every thread of the room
+func (s *ValkeyChatStore) UnsubscribeFromThreads(roomID, userID string) error {
+	parentIDs, err := s.Client.SMembers(s.Ctx, userThreadsKey(roomID, userID)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get user threads: %w", err)
+	}
+
+	pipe := s.Client.Pipeline()
+	for _, parentID := range parentIDs {
+		pipe.SRem(s.Ctx, threadSubscribersKey(roomID, parentID), userID)
+	}
+	pipe.Del(s.Ctx, userThreadsKey(roomID, userID))
+	if _, err := pipe.Exec(s.Ctx); err != nil {
+		return fmt.Errorf("failed to unsubscribe from threads: %w", err)
+	}
+	return nil
+}
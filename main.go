@@ -25,6 +25,20 @@ func main() {
 
 	chat.Chat_init() 
 
+	// Start the ChatService gRPC server next to the HTTP server
+	go func() {
+		if err := chat.ServeGrpc(server.GrpcHost, server.GrpcPort); err != nil {
+			log.Fatalf("Failed to run gRPC server: %v", err)
+		}
+	}()
+
+	// Serve the slow-consumer metrics on the internal listener
+	go func() {
+		if err := chat.ServeMetrics(server.MetricsAddr); err != nil {
+			log.Fatalf("Failed to run metrics server: %v", err)
+		}
+	}()
+
 	// Set up HTTP routes
 	handler.Handles(server.Router)
 
@@ -35,8 +49,6 @@ func main() {
 	}
 	defer config.Client.Close()
 
-	println("gRPC server running...")
-
 	println("Server started....")
 	// Start HTTP server
 	server.Router.Run(fmt.Sprintf("0.0.0.0:%s", server.Port))
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
 	UUID      string `json:"uuid"`
@@ -27,10 +30,224 @@ type User struct {
 // }
 
 type Message struct {
-	ID        string `json:"id"`
+	ID         string            `json:"id"`
+	RoomID     string            `json:"room_id"`
+	SenderID   string            `json:"sender_id"`
+	ReceiverID string            `json:"receiver_id,omitempty"` // Set on direct messages
+	TargetID   string            `json:"target_id,omitempty"`   // Message this one refers to, e.g. the one being acknowledged, or the user being moderated
+	Content    string            `json:"content"`
+	Type       string            `json:"type"`
+	Timestamp  int64             `json:"timestamp,omitempty"`
+	Payload    json.RawMessage   `json:"payload,omitempty"` // Type specific data, e.g. a history page
+	EditedAt   int64             `json:"edited_at,omitempty"`
+	Deleted    bool              `json:"deleted,omitempty"` // Tombstone left by a "delete", the content is gone
+	Reactions  []ReactionSummary `json:"reactions,omitempty"`
+
+	// Threads: replies carry their parent's ID, parents carry a summary of their replies
+	ParentID    string `json:"parent_id,omitempty"`
+	ReplyCount  int    `json:"reply_count,omitempty"`
+	LastReplyAt int64  `json:"last_reply_at,omitempty"`
+
+	// Position in the room's history, set on every stored message so clients can resume
+	Seq int64 `json:"seq,omitempty"`
+
+	// When the message disappears, 0 unless its room has a message TTL
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// JoinRequest is the optional payload of a "join" message
+type JoinRequest struct {
+	LastSeq int64 `json:"last_seq"` // Last sequence number the client saw in the room, its messages after it are replayed
+}
+
+// ResumeSummary is the payload of the "resumed" message sent once a replay is over
+type ResumeSummary struct {
+	FromSeq   int64 `json:"from_seq"`
+	ToSeq     int64 `json:"to_seq"` // Last sequence number replayed, live delivery continues after it
+	Replayed  int   `json:"replayed"`
+	Truncated bool  `json:"truncated,omitempty"` // More was missed than a replay sends, fetch the rest with "history"
+}
+
+// HistoryRequest is the payload of a "history" message sent by a client
+type HistoryRequest struct {
+	Before string `json:"before"` // Cursor returned by the previous page, empty for the newest messages
+	Limit  int    `json:"limit"`
+}
+
+// HistoryPage is a page of stored room messages, oldest first
+type HistoryPage struct {
+	RoomID     string     `json:"room_id"`
+	ParentID   string     `json:"parent_id,omitempty"` // Set when the page holds the replies of a thread
+	Messages   []*Message `json:"messages"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// Presence is a user's connection state, pushed in "presence" messages and returned by the presence API
+type Presence struct {
+	UserID   string `json:"user_id"`
+	Status   string `json:"status"` // "online", "away" or "offline"
+	LastSeen int64  `json:"last_seen,omitempty"`
+}
+
+// Receipt tells a sender that one of their messages was delivered to or read by a member
+type Receipt struct {
+	MessageID string `json:"message_id"`
+	RoomID    string `json:"room_id"`
+	UserID    string `json:"user_id"`
+	Status    string `json:"status"` // "delivered" or "read"
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ReadCursor is the last message a member has read in a room
+type ReadCursor struct {
+	UserID    string `json:"user_id"`
+	MessageID string `json:"message_id"`
+	Timestamp int64  `json:"timestamp"` // Timestamp of the message, cursors only move forward
+	ReadAt    int64  `json:"read_at"`
+}
+
+// MessageRevision is a previous content of an edited message
+type MessageRevision struct {
+	Content  string `json:"content"`
+	EditedBy string `json:"edited_by"`
+	EditedAt int64  `json:"edited_at"` // When this content was replaced
+}
+
+// ReactionSummary aggregates the reactions to a message with one emoji
+type ReactionSummary struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"` // Who reacted, in the order they did
+}
+
+// Mention is an entry of a user's mention inbox
+type Mention struct {
+	MessageID string `json:"message_id"`
 	RoomID    string `json:"room_id"`
 	SenderID  string `json:"sender_id"`
 	Content   string `json:"content"`
-	Type      string `json:"type"`
-	Timestamp int64  `json:"timestamp,omitempty"`
+	Everyone  bool   `json:"everyone,omitempty"` // Mentioned through @room rather than by name
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Gap is the payload of a "gap" message, sent once a client reads fast enough again
+// after messages were dropped for it. Clients catch up with "history" or a resuming "join".
+type Gap struct {
+	Dropped int      `json:"dropped"`
+	Since   int64    `json:"since"`           // When the first message was dropped
+	Rooms   []string `json:"rooms,omitempty"` // Rooms that lost messages
+}
+
+// RateLimited is the payload of a "rate_limited" message, sent instead of handling a message
+type RateLimited struct {
+	Scope        string `json:"scope"` // "connection", "user" or "room"
+	RetryAfterMs int64  `json:"retry_after_ms"`
+}
+
+// ModerationRequest asks for a moderation action on a room member
+type ModerationRequest struct {
+	Action          string `json:"action"` // "mute", "unmute", "kick", "ban" or "unban"
+	UserID          string `json:"user_id"`
+	DurationSeconds int64  `json:"duration_seconds"` // 0 for no expiry, a timed kick keeps the user out until it expires
+	Reason          string `json:"reason"`
+}
+
+// Moderation is a moderation action taken in a room, it is the payload of the broadcast announcing it
+type Moderation struct {
+	Action    string `json:"action"`
+	RoomID    string `json:"room_id"`
+	UserID    string `json:"user_id"`
+	By        string `json:"by"`
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at,omitempty"` // 0 when it doesn't expire
+}
+
+// RoleDefinition lists the permissions of a room role
+type RoleDefinition struct {
+	Permissions []string `json:"permissions"`
+}
+
+// RoleAssignment gives a room member a role
+type RoleAssignment struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// RoomRoles describes the roles of a room and who holds them
+type RoomRoles struct {
+	Roles   map[string][]string `json:"roles"`   // Permissions of every role, built-in and custom
+	Members map[string]string   `json:"members"` // Role of every authorized member
+}
+
+// CreateInviteRequest is the body of a request creating an invite to a room
+type CreateInviteRequest struct {
+	MaxUses          int    `json:"max_uses"`           // 0 for no limit
+	ExpiresInSeconds int64  `json:"expires_in_seconds"` // 0 for the default expiry
+	Role             string `json:"role"`               // Role given to whoever redeems it, member when empty
+}
+
+// Invite lets whoever holds its token become a member of a room
+type Invite struct {
+	Token     string `json:"token"`
+	RoomID    string `json:"room_id"`
+	CreatedBy string `json:"created_by"`
+	Role      string `json:"role,omitempty"`
+	MaxUses   int    `json:"max_uses"` // 0 for no limit
+	Uses      int    `json:"uses"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// MembershipRequest is a user's pending request to join a private room
+type MembershipRequest struct {
+	RoomID    string `json:"room_id"`
+	UserID    string `json:"user_id"`
+	UserName  string `json:"user_name"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// MembershipRequestNote is the body of a request to join a private room
+type MembershipRequestNote struct {
+	Note string `json:"note"`
+}
+
+// MembershipDecision is the body of an admin's answer to a join request
+type MembershipDecision struct {
+	Approve bool `json:"approve"`
+}
+
+// OwnershipTransfer is the body of a request handing a room to another of its admins
+type OwnershipTransfer struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
+// DirectoryRoom is a public room as listed in the room directory
+type DirectoryRoom struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	RoomType    string `json:"room_type,omitempty"`
+	MemberCount int64  `json:"member_count"`
+	ActiveCount int64  `json:"active_count"` // Members active in the room on any node
+	Archived    bool   `json:"archived,omitempty"`
+	CreatedAt   int64  `json:"created_at,omitempty"`
+}
+
+// DirectoryPage is one page of the room directory
+type DirectoryPage struct {
+	Rooms      []DirectoryRoom `json:"rooms"`
+	NextCursor string          `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// RoomLimits are the capacity limits of a room, 0 for no limit
+type RoomLimits struct {
+	CountLimit  int `json:"count_limit"`  // Most authorized members
+	ActiveLimit int `json:"active_limit"` // Most members active at once, on every node
+}
+
+// RetentionSetting is how long a room's messages last before they disappear, it is the
+// payload of the "retention" broadcast announcing a change
+type RetentionSetting struct {
+	TTLSeconds int64 `json:"ttl_seconds"` // 0 keeps messages
 }
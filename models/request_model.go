@@ -8,12 +8,14 @@ type CreateRoomRequest struct {
 }
 
 type RoomInfo struct {
-	Name        string `json:"name" binding:"required"`
-	CreatorID   string `json:"creator_id" binding:"required"`
-	RoomType    string `json:"room_type" binding:"required"`
-	IsPrivate   bool   `json:"is_private"`
-	Description string `json:"room_description"`
-	// CountLimit   int           `json:"count_limit"`
+	Name         string        `json:"name" binding:"required"`
+	CreatorID    string        `json:"creator_id" binding:"required"`
+	RoomType     string        `json:"room_type" binding:"required"`
+	IsPrivate    bool          `json:"is_private"`
+	Description  string        `json:"room_description"`
+	CountLimit   int           `json:"count_limit"`   // Most authorized members, 0 for no limit
+	ActiveLimit  int           `json:"active_limit"`  // Most members active at once, 0 for no limit
+	AllowReplies bool          `json:"allow_replies"` // Lets members reply in threads of an announcement room
 	Participants []ContactInfo `json:"participants"`
 	Timestamp    time.Time     `json:"timestamp"`
 }
@@ -27,50 +27,54 @@ func AuthRequired() gin.HandlerFunc {
 		// Extract the token from the header
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// Parse and validate the token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-
-			// Return the secret key used to sign the token
-			return []byte("your-secret-key"), nil // Use your actual secret key
-		})
-
+		userUUID, err := ParseUserToken(tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		// Check if the token is valid
-		if !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
+		// Set the user UUID in the context for handlers to use
+		c.Set("userUUID", userUUID)
+		c.Next()
+	}
+}
 
-		// Extract claims from the token
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
-			return
+// ParseUserToken validates a bearer token and returns the UUID of the user it was issued to
+func ParseUserToken(tokenString string) (string, error) {
+	// Parse and validate the token
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Validate the signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-		// Extract the user UUID from the claims
-		userUUID, ok := claims["user_id"].(string)
-		if !ok || userUUID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
-			c.Abort()
-			return
-		}
+		// Return the secret key used to sign the token
+		return []byte("your-secret-key"), nil // Use your actual secret key
+	})
 
-		// Set the user UUID in the context for handlers to use
-		c.Set("userUUID", userUUID)
-		c.Next()
+	if err != nil {
+		return "", fmt.Errorf("Invalid token: %w", err)
+	}
+
+	// Check if the token is valid
+	if !token.Valid {
+		return "", fmt.Errorf("Invalid token")
+	}
+
+	// Extract claims from the token
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", fmt.Errorf("Invalid token claims")
 	}
+
+	// Extract the user UUID from the claims
+	userUUID, ok := claims["user_id"].(string)
+	if !ok || userUUID == "" {
+		return "", fmt.Errorf("Invalid user ID in token")
+	}
+
+	return userUUID, nil
 }
 
 func ValidateTokenCLI(c *gin.Context) {
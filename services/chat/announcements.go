@@ -0,0 +1,21 @@
+package chat
+
+// RoomTypeAnnouncement rooms are read-only for their members, only those allowed to
+// announce post in them. Members may reply in threads if the room allows replies
+const RoomTypeAnnouncement = "announcement"
+
+// postPermission returns the permission needed to post a message in the room, a reply when parentID is set
+func (r *Room) postPermission(parentID string) Permission {
+	if r.RoomType != RoomTypeAnnouncement {
+		return PermPost
+	}
+
+	r.mutex.RLock()
+	allowReplies := r.AllowReplies
+	r.mutex.RUnlock()
+
+	if parentID != "" && allowReplies {
+		return PermPost
+	}
+	return PermAnnounce
+}
@@ -0,0 +1,20 @@
+package chat
+
+// Commands waiting for the background worker before queueing more blocks
+const backgroundQueueSize = 4096
+
+// runInBackground queues work the sender doesn't need to wait for, such as filling the
+// mention inboxes and offline queues of a room's members. One goroutine runs it in the
+// order it was queued, so a user's offline queue keeps the order of the room
+func (cm *ChatManager) runInBackground(command func()) {
+	cm.backgroundGo.Do(func() {
+		cm.background = make(chan func(), backgroundQueueSize)
+		go func() {
+			for command := range cm.background {
+				command()
+			}
+		}()
+	})
+
+	cm.background <- command
+}
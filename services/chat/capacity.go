@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+)
+
+// ErrRoomFull is returned when a room has reached its count_limit or active_limit
+var ErrRoomFull = errors.New("This room is full")
+
+// errNotAuthorized is returned when a user who is not a member joins a private room
+var errNotAuthorized = errors.New("You are not authorized to join this room")
+
+// admitMember authorizes the user in Valkey unless the room has reached its count_limit,
+// a failing Valkey lets them in rather than locking everyone out
+func admitMember(roomID, userID string) error {
+	admitted, _, err := db.Valkey.AdmitRoomMember(roomID, userID)
+	if err != nil {
+		log.Printf("Error admitting %s to room %s: %v", userID, roomID, err)
+		return nil
+	}
+	if !admitted {
+		return ErrRoomFull
+	}
+	return nil
+}
+
+// admitActive marks the user active on this node in Valkey unless the room has reached its active_limit
+func (cm *ChatManager) admitActive(roomID, userID string) error {
+	admitted, err := db.Valkey.AdmitActiveMember(roomID, userID, cm.nodeID, activeTTL)
+	if err != nil {
+		log.Printf("Error admitting %s as active in room %s: %v", userID, roomID, err)
+		return nil
+	}
+	if !admitted {
+		return ErrRoomFull
+	}
+	return nil
+}
+
+// sendRoomFull tells the client the room has no space left for them
+func sendRoomFull(c *Client, roomID string) {
+	c.reject(NewMessage(roomID, "system", ErrRoomFull.Error(), "room_full"))
+}
+
+// GetRoomLimits returns the room's capacity limits to one of its members
+func GetRoomLimits(roomID, userID string) (models.RoomLimits, error) {
+	if !manager.Authorize(roomID, userID, PermRead) {
+		return models.RoomLimits{}, fmt.Errorf("not a member of room %s", roomID)
+	}
+
+	return db.Valkey.GetRoomLimits(roomID)
+}
+
+// SetRoomLimits changes the room's capacity limits, members past a lowered limit stay
+func (cm *ChatManager) SetRoomLimits(roomID, userID string, limits models.RoomLimits) error {
+	if !cm.Authorize(roomID, userID, PermEditRoom) {
+		return fmt.Errorf("You are not allowed to edit this room")
+	}
+
+	if limits.CountLimit < 0 || limits.ActiveLimit < 0 {
+		return fmt.Errorf("Limits can't be negative")
+	}
+
+	if err := db.Valkey.SetRoomLimits(roomID, limits); err != nil {
+		log.Printf("Error setting limits of room %s: %v", roomID, err)
+		return fmt.Errorf("Unable to update room")
+	}
+
+	log.Printf("User %s set the limits of room %s to %d members, %d active",
+		userID, roomID, limits.CountLimit, limits.ActiveLimit)
+	return nil
+}
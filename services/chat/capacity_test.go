@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+)
+
+// A user joins a room only while it has space for another member and another active member,
+// a failing Valkey lets them in
+func TestJoinCapacity(t *testing.T) {
+	failed := int64(-1) // the script fails
+	tests := []struct {
+		name       string
+		private    bool
+		member     bool  // already authorized
+		admit      int64 // admit script: 1 added, 2 already a member, 0 full
+		active     int64 // active script: 1 admitted, 0 full
+		wantErr    error
+		wantMember bool
+		wantActive bool
+	}{
+		{"space", false, false, 1, 1, nil, true, true},
+		{"member through another node", false, false, 2, 1, nil, true, true},
+		{"count limit reached", false, false, 0, 1, ErrRoomFull, false, false},
+		{"active limit reached", false, false, 1, 0, ErrRoomFull, true, false},
+		{"member, active limit reached", false, true, 0, 0, ErrRoomFull, true, false},
+		{"member, space", false, true, 0, 1, nil, true, true},
+		{"valkey down", false, false, failed, failed, nil, true, true},
+		{"private, not a member", true, false, 1, 1, errNotAuthorized, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := func(result int64) (interface{}, error) {
+				if result == failed {
+					return nil, errors.New("valkey is down")
+				}
+				return result, nil
+			}
+
+			fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+				"evalsha": func(args []interface{}) (interface{}, error) {
+					if isScriptOn(args, "chat:room:lounge:active") {
+						return reply(tt.active)
+					}
+					return reply(tt.admit)
+				},
+			})
+
+			cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client), bus: NewRoomBus()}
+			room := cm.addRoom(NewRoom("lounge", "Lounge", "owner", tt.private))
+			if tt.member {
+				room.AuthorizedMembers["bob"] = true
+			}
+			client := NewClient("bob", "Bob", nil, cm)
+
+			if err := room.Join(client); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Join = %v, want %v", err, tt.wantErr)
+			}
+			if got := room.IsAuthorized("bob"); got != tt.wantMember {
+				t.Errorf("member = %v, want %v", got, tt.wantMember)
+			}
+			if got := room.IsActive("bob"); got != tt.wantActive {
+				t.Errorf("active = %v, want %v", got, tt.wantActive)
+			}
+
+			admits := fake.count(func(args []interface{}) bool { return isScriptOn(args, "chat:room:lounge:auth") })
+			if wantAdmits := !tt.member && !tt.private; (admits > 0) != wantAdmits {
+				t.Errorf("admit script run %d times, want run %v", admits, wantAdmits)
+			}
+		})
+	}
+}
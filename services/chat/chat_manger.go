@@ -3,117 +3,273 @@ package chat
 import (
 	"fmt"
 	"log"
+	"os"
+	db "raychat/database"
 	"raychat/models"
 	"sync"
+
+	"github.com/google/uuid"
 )
 
 // ChatManager handles all chat operations
 /*
-The ChatManager is designed to be the central coordinator for your entire chat system.
+The ChatManager is the registry of the chat system.
 It maintains:
-- A map of all rooms (Rooms)
+- A map of all rooms (Rooms), each room runs its own goroutine for membership and fan-out
 - A map of all connected clients (Clients)
-- Channels for communication between different parts of the system
+- The room bus connecting it to the other nodes
 */
 type ChatManager struct {
-	Rooms      map[string]*Room
-	Clients    map[string]*Client //client are the users which are online
-	Broadcast  chan *models.Message
-	Register   chan *Client
-	Unregister chan *Client
-	mutex      sync.RWMutex
+	Rooms    map[string]*Room
+	Clients  map[string]*Client //client are the users which are online
+	bus      *RoomBus
+	presence *PresenceService
+	typing   *TypingTracker
+	// What happens to messages for clients that don't keep up
+	slowConsumer SlowConsumerPolicy
+	rateLimits   RateLimitConfig // limits on inbound messages
+	nodeID       string          // names this node's entries in the rooms' active sets
+	background   chan func()     // work done off the clients' read loops, see runInBackground
+	backgroundGo sync.Once       // starts the background worker on first use
+	mutex        sync.RWMutex    // guards the Rooms and Clients maps, not the rooms themselves
 	// Store      *db.ValkeyChatStore
 }
 
 // NewChatManager creates a new chat manager
 func NewChatManager() *ChatManager {
 	cm := &ChatManager{
-		Rooms:      make(map[string]*Room),
-		Clients:    make(map[string]*Client),
-		Broadcast:  make(chan *models.Message),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
+		Rooms:   make(map[string]*Room),
+		Clients: make(map[string]*Client),
+		bus:     NewRoomBus(),
 	}
+	cm.slowConsumer = loadSlowConsumerPolicy()
+	cm.rateLimits = loadRateLimits()
+	cm.nodeID = loadNodeID()
+	cm.presence = NewPresenceService(cm)
+	cm.typing = NewTypingTracker(cm)
 
 	// Load rooms using database package
 	if err := cm.loadAllRooms(); err != nil {
 		log.Printf("Error loading rooms: %v", err)
 	}
 
+	// Users who were active here before a restart are not anymore
+	if err := db.Valkey.DropLegacyActiveSets(); err != nil {
+		log.Printf("Error dropping legacy active sets: %v", err)
+	}
+	if err := db.Valkey.ClearNodeActive(cm.nodeID); err != nil {
+		log.Printf("Error clearing active members of node %s: %v", cm.nodeID, err)
+	}
+
 	return cm
 }
 
+// loadNodeID reads NODE_ID, falling back to the host name, so a restarted node finds the
+// active members it left behind
+func loadNodeID() string {
+	if nodeID := os.Getenv("NODE_ID"); nodeID != "" {
+		return nodeID
+	}
+
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+
+	return uuid.New().String()
+}
+
+// Start receives the broadcasts of subscribed rooms from every node, this one included,
+// and hands them to the rooms, it blocks until the bus is closed
 func (cm *ChatManager) Start() {
 	log.Println("Chat manager started")
 
-	for {
-		select {
-		case client := <-cm.Register: //client is recieved from the Register channel
-			log.Printf("Registering client: %s", client.UserID)
-			cm.mutex.Lock()
-			cm.Clients[client.UserID] = client //adds to the Client map
-			cm.mutex.Unlock()
-
-		case client := <-cm.Unregister:
-			log.Printf("Unregistering client: %s", client.UserID)
-			cm.mutex.Lock()
-			if _, exists := cm.Clients[client.UserID]; exists { //if client exists then remove it from all the rooms
-
-				//Remove client from all rooms
-				for roomID := range client.Rooms {
-					if room, exists := cm.Rooms[roomID]; exists {
-						delete(room.ActiveMembers, client.UserID) //delete from the room
-						// Update Valkey
-						// if err := cm.Store.SetUserInactive(client.UserID, roomID); err != nil {
-						// 	log.Printf("Error marking user inactive: %v", err)
-						// }
-						log.Printf("Removed Client %s, from room %s", client.UserID, roomID)
-					}
-				}
-
-				//Close send channel and delete Client
-				close(client.Send)
-				delete(cm.Clients, client.UserID)
-			}
-			cm.mutex.Unlock()
-
-		case message := <-cm.Broadcast:
-			log.Printf("Recieved bradcast message for room: %s", message.RoomID)
-			cm.mutex.RLock()
-			room, exists := cm.Rooms[message.RoomID]
-			cm.mutex.RUnlock()
-
-			if exists {
-				log.Printf("Broadcasting message to room %s with %d members", message.RoomID, len(room.ActiveMembers))
-
-				//Send Message to all the members in the room
-				for userID := range room.AuthorizedMembers {
-
-					//check if the user is currently online
-					if client, isActive := room.ActiveMembers[userID]; isActive {
-
-						//Find all the clients for this user
-						select {
-						case client.Send <- message:
-
-						default:
-							// Client's buffer is full, clean up
-							cm.mutex.Lock()
-							delete(room.ActiveMembers, userID)
-							close(client.Send)
-							delete(cm.Clients, client.UserID)
-							cm.mutex.Unlock()
-						}
-
-					} else {
-						//User offline
-						//make a fucntion to send the message later
-					}
-				}
-				log.Printf("Message broadcast complete")
-			}
+	// Mark idle users away
+	go cm.presence.Run()
+
+	// Remove messages of rooms with a message TTL once they expire
+	go cm.runRetention()
+
+	// Keep this node's active members from lapsing
+	go cm.runActiveHeartbeat()
+
+	cm.bus.Listen(cm.deliverLocal, cm.deliverToUser)
+}
+
+// Register adds a connected client to the registry, replacing an earlier connection of the user
+func (cm *ChatManager) Register(client *Client) {
+	log.Printf("Registering client: %s", client.UserID)
+	cm.mutex.Lock()
+	cm.Clients[client.UserID] = client //adds to the Client map
+	cm.mutex.Unlock()
+
+	cm.registered(client)
+}
+
+// RegisterIfAbsent adds a connected client to the registry unless the user is already connected,
+// it reports whether the client was added
+func (cm *ChatManager) RegisterIfAbsent(client *Client) bool {
+	cm.mutex.Lock()
+	if _, exists := cm.Clients[client.UserID]; exists {
+		cm.mutex.Unlock()
+		return false
+	}
+	cm.Clients[client.UserID] = client
+	cm.mutex.Unlock()
+
+	log.Printf("Registering client: %s", client.UserID)
+	cm.registered(client)
+	return true
+}
+
+func (cm *ChatManager) registered(client *Client) {
+	cm.bus.SubscribeUser(client.UserID)
+	cm.presence.Connected(client)
+}
+
+// Unregister removes the client from its rooms and the registry and closes its Send channel
+func (cm *ChatManager) Unregister(client *Client) {
+	cm.mutex.Lock()
+	current, exists := cm.Clients[client.UserID]
+	registered := exists && current == client
+	if registered {
+		delete(cm.Clients, client.UserID)
+	}
+	cm.mutex.Unlock()
+
+	//Remove client from all rooms
+	roomIDs := client.roomIDs()
+	for _, roomID := range roomIDs {
+		if room, exists := cm.GetRoom(roomID); exists {
+			room.Drop(client) //delete from the room
+			log.Printf("Removed Client %s, from room %s", client.UserID, roomID)
 		}
 	}
+
+	// Nothing delivers to the client anymore
+	client.closeSend()
+
+	if registered {
+		log.Printf("Unregistered client: %s", client.UserID)
+		cm.bus.UnsubscribeUser(client.UserID)
+	}
+
+	// A replaced connection still stops counting, the user stays online through the newer one
+	cm.presence.Disconnected(client)
+}
+
+// Broadcast sends a message to every member of its room, on every node
+func (cm *ChatManager) Broadcast(message *models.Message) {
+	cm.publish(message)
+}
+
+// SendToUser sends a message to a single user, wherever they are connected
+func (cm *ChatManager) SendToUser(userMessage *UserMessage) {
+	if err := cm.bus.PublishToUser(userMessage.UserID, userMessage.Message); err != nil {
+		log.Printf("Error publishing message to user, delivering locally only: %v", err)
+		cm.deliverToUser(userMessage)
+	}
+}
+
+// SendToUsers sends messages to single users wherever they are connected, in one round trip
+func (cm *ChatManager) SendToUsers(messages []*UserMessage) {
+	if len(messages) == 0 {
+		return
+	}
+
+	if err := cm.bus.PublishToUsers(messages); err != nil {
+		log.Printf("Error publishing messages to users, delivering locally only: %v", err)
+		for _, userMessage := range messages {
+			cm.deliverToUser(userMessage)
+		}
+	}
+}
+
+// publish sends a room message to every node with members in the room, the message
+// comes back to this node through deliverLocal
+// Members who are not active anywhere get it in their offline queue instead, filled in the background
+func (cm *ChatManager) publish(message *models.Message) {
+	cm.storeEvent(message)
+	if isQueued(message.Type) {
+		cm.runInBackground(func() { cm.queueForInactive(message) })
+	}
+
+	if err := cm.bus.Publish(message); err != nil {
+		log.Printf("Error publishing message, delivering locally only: %v", err)
+		cm.deliverLocal(message)
+	}
+}
+
+// deliverLocal hands a room message to the room, which sends it to its members connected to this node
+func (cm *ChatManager) deliverLocal(message *models.Message) {
+	if room, exists := cm.GetRoom(message.RoomID); exists {
+		room.deliver(message)
+	}
+}
+
+// deliverToUser sends a message to a single user if they are connected to this node
+func (cm *ChatManager) deliverToUser(userMessage *UserMessage) {
+	client, exists := cm.GetClient(userMessage.UserID)
+	if !exists {
+		return
+	}
+
+	// Direct messages pull both users into the room, no explicit join needed
+	// The join waits on the room, so it runs aside rather than holding up the bus
+	if userMessage.Message.Type == "dm" {
+		go cm.joinDirectRoom(client, userMessage.Message.RoomID)
+	}
+
+	sendToClient(client, userMessage.Message)
+}
+
+// GetClient returns the client of a user connected to this node
+func (cm *ChatManager) GetClient(userID string) (*Client, bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	client, exists := cm.Clients[userID]
+	return client, exists
+}
+
+// RoomMembers returns the IDs of the room's authorized members
+func (cm *ChatManager) RoomMembers(roomID string) []string {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return nil
+	}
+
+	return room.Members()
+}
+
+// IsActiveMember reports whether the user is an active member of the room on this node
+func (cm *ChatManager) IsActiveMember(roomID, userID string) bool {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return false
+	}
+
+	return room.IsActive(userID)
+}
+
+// RemoveActiveMember removes the user from the room's active members
+func (cm *ChatManager) RemoveActiveMember(roomID, userID string) {
+	if room, exists := cm.GetRoom(roomID); exists {
+		room.Deactivate(userID)
+	}
+}
+
+// addRoom registers a room unless one with the same ID already is, it returns the registered room
+func (cm *ChatManager) addRoom(room *Room) *Room {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if existing, exists := cm.Rooms[room.ID]; exists {
+		return existing
+	}
+
+	room.manager = cm
+	cm.Rooms[room.ID] = room
+	return room
 }
 
 func (cm *ChatManager) loadAllRooms() error {
@@ -122,11 +278,11 @@ func (cm *ChatManager) loadAllRooms() error {
 		return err
 	}
 
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
 	for _, room := range rooms {
-		cm.Rooms[room.ID] = room
+		cm.addRoom(room)
+
+		// Rooms created before the directory existed are listed on the first start
+		indexRoom(room.ID, room.Name, room.RoomType, room.IsPrivate)
 	}
 
 	log.Printf("Loaded %d rooms from database", len(rooms))
@@ -134,26 +290,21 @@ func (cm *ChatManager) loadAllRooms() error {
 }
 
 // CreateRoom creates a new chat room
-func (cm *ChatManager) CreateRoom(roomId, name string, creatorID string, isPrivate bool) *Room {
+func (cm *ChatManager) CreateRoom(roomId, name string, creatorID string, isPrivate bool) (*Room, error) {
 	//This function will create a room and add it to the Chat Manager
 
 	room := NewRoom(roomId, name, creatorID, isPrivate)
 
-	// Initialize maps
-	room.AuthorizedMembers = make(map[string]bool)
-	room.Admins = make(map[string]bool)
-	room.ActiveMembers = make(map[string]*Client)
-
 	// Creator is both admin and authorized member
 	room.AuthorizedMembers[creatorID] = true
 	room.Admins[creatorID] = true
 
-	cm.mutex.Lock()
-	cm.Rooms[room.ID] = room
-	cm.mutex.Unlock()
+	if cm.addRoom(room) != room {
+		return nil, ErrRoomExists
+	}
 
 	log.Printf("Created room: %s, creator: %s", room.ID, creatorID)
-	return room
+	return room, nil
 }
 
 // GetRoom returns a room by ID
@@ -165,53 +316,69 @@ func (cm *ChatManager) GetRoom(roomID string) (*Room, bool) {
 	return room, exists
 }
 
-// JoinRoom adds a user to a room if they are authorized
-func (cm *ChatManager) JoinRoom(roomID, userID string) bool {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	room, exists := cm.Rooms[roomID]
+// syncRoom picks up changes made by other nodes: it loads a room created elsewhere,
+// or refreshes the user's membership of a private room from Valkey
+func (cm *ChatManager) syncRoom(roomID, userID string) {
+	room, exists := cm.GetRoom(roomID)
 	if !exists {
-		return false
-	}
-
-	// Check if the room is private and if the user is authorized
-	if room.IsPrivate {
-		if _, ok := room.AuthorizedMembers[userID]; !ok {
-			log.Printf("User %s not authorized for private room %s", userID, roomID)
-			return false
+		loaded, err := LoadRoomFromValkey(roomID)
+		if err != nil {
+			return
 		}
-	} else {
-		// Add to authorized members if not already (for public rooms)
-		if _, ok := room.AuthorizedMembers[userID]; !ok {
-			room.AuthorizedMembers[userID] = true
+
+		if cm.addRoom(loaded) == loaded {
+			log.Printf("Loaded room %s from Valkey", roomID)
 		}
+		return
+	}
+
+	if !room.IsPrivate || room.IsAuthorized(userID) {
+		return
+	}
+
+	if isMember, err := IsUserAuthorizedMember(roomID, userID); err == nil && isMember {
+		room.Authorize(userID)
+	}
+}
+
+// JoinRoom adds a user to a room if they are authorized and it isn't full
+func (cm *ChatManager) JoinRoom(roomID, userID string) error {
+	cm.syncRoom(roomID, userID)
+
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return errNotAuthorized
 	}
 
-	userClient, exists := cm.Clients[userID]
+	userClient, exists := cm.GetClient(userID)
 	if !exists {
-		log.Println("No active client found for user %s", userID)
-		return false
+		log.Printf("No active client found for user %s", userID)
+		return errNotAuthorized
+	}
+
+	if cm.isKeptOut(roomID, userID) {
+		log.Printf("User %s is banned or kicked from room %s", userID, roomID)
+		return errNotAuthorized
+	}
+
+	// Private rooms only let authorized members in
+	if err := room.Join(userClient); err != nil {
+		return err
 	}
-	// Add to active members
-	room.ActiveMembers[userID] = userClient
 
 	log.Printf("User %s joined room %s, room now has %d active members",
-		userID, roomID, len(room.ActiveMembers))
+		userID, roomID, room.ActiveCount())
 
-	return true
+	return nil
 }
 
 func (cm *ChatManager) AddAuthorizedMemberUnrestricted(roomID, userID, requestedByID string) error {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	room, exists := cm.Rooms[roomID]
+	room, exists := cm.GetRoom(roomID)
 	if !exists {
 		return fmt.Errorf("room does not exists")
 	}
 
-	room.AuthorizedMembers[userID] = true
+	room.Authorize(userID)
 	log.Printf("User %s added to authorized members of room %s by %s",
 		userID, roomID, requestedByID)
 
@@ -220,23 +387,23 @@ func (cm *ChatManager) AddAuthorizedMemberUnrestricted(roomID, userID, requested
 
 // AddAuthorizedMember adds a user to the authorized members list
 func (cm *ChatManager) AddAuthorizedMember(roomID, userID, requestedByID string) error {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	room, exists := cm.Rooms[roomID]
+	room, exists := cm.GetRoom(roomID)
 	if !exists {
 		return fmt.Errorf("room does not exists")
 	}
 
-	// Check if the requesting user has permission (owner or admin)
-	// if room.CreatorID != requestedByID && !room.Admins[requestedByID] {
-	if !room.Admins[requestedByID] { // only check if the admin has sent the request
+	// Check if the requesting user may invite members
+	if !room.Can(requestedByID, PermInvite) {
 		log.Printf("User %s attempted to add member to room %s but lacks permission",
 			requestedByID, roomID)
 		return fmt.Errorf("Unauthorized to get added to the room")
 	}
 
-	room.AuthorizedMembers[userID] = true
+	if err := AddUserToRoomAuthMembers(roomID, userID); err != nil {
+		return err
+	}
+
+	room.Authorize(userID)
 	log.Printf("User %s added to authorized members of room %s by %s",
 		userID, roomID, requestedByID)
 
@@ -245,28 +412,24 @@ func (cm *ChatManager) AddAuthorizedMember(roomID, userID, requestedByID string)
 
 // RemoveAuthorizedMember removes a user from the authorized members list
 func (cm *ChatManager) RemoveAuthorizedMember(roomID, userID, requestedByID string) bool {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	room, exists := cm.Rooms[roomID]
+	room, exists := cm.GetRoom(roomID)
 	if !exists {
 		return false
 	}
 
-	// Check if the requesting user has permission (owner or admin)
-	if room.CreatorID != requestedByID && !room.Admins[requestedByID] {
+	// Check if the requesting user may remove members, and ranks above the one removed
+	if !room.Can(requestedByID, PermKick) || !room.Outranks(requestedByID, userID) {
 		return false
 	}
 
 	// Cannot remove the creator
-	if userID == room.CreatorID {
+	if userID == room.Owner() {
 		return false
 	}
 
-	delete(room.AuthorizedMembers, userID)
-
-	// Also remove from active members if they're currently active
-	delete(room.ActiveMembers, userID)
+	// Also removes them from active members if they're currently active
+	room.Deauthorize(userID)
+	unfollowThreads(roomID, userID)
 
 	log.Printf("User %s removed from authorized members of room %s by %s",
 		userID, roomID, requestedByID)
@@ -276,38 +439,19 @@ func (cm *ChatManager) RemoveAuthorizedMember(roomID, userID, requestedByID stri
 
 // LeaveRoom removes a user from a room's active members
 func (cm *ChatManager) LeaveRoom(roomID, userID string) bool {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	room, exists := cm.Rooms[roomID]
+	room, exists := cm.GetRoom(roomID)
 	if !exists {
 		return false
 	}
 
 	// Check if user is actually in the room
-	if _, isActive := room.ActiveMembers[userID]; !isActive {
+	if !room.Deactivate(userID) {
 		return false
 	}
-
-	// Remove from active members
-	delete(room.ActiveMembers, userID)
-
-	// Update client's room list if they're online
-	for _, client := range cm.Clients {
-		if client.UserID == userID {
-			delete(client.Rooms, roomID)
-			break
-		}
-	}
+	unfollowThreads(roomID, userID)
 
 	log.Printf("User %s left room %s, room now has %d active members",
-		userID, roomID, len(room.ActiveMembers))
-
-	// Optional: Check if room is empty and perform cleanup if needed
-	if len(room.ActiveMembers) == 0 && !room.IsPrivate {
-		log.Printf("Room %s is now empty", roomID)
-		// You could add logic here to delete temporary rooms if desired
-	}
+		userID, roomID, room.ActiveCount())
 
 	return true
 }
@@ -2,7 +2,9 @@
 package chat
 
 import (
+	"errors"
 	"log"
+	db "raychat/database"
 	"raychat/models"
 
 	"github.com/gorilla/websocket"
@@ -11,6 +13,9 @@ import (
 // Global instance of the chat manager
 var manager *ChatManager
 
+// ErrRoomExists is returned when a room is created with an ID that is already taken
+var ErrRoomExists = errors.New("A room with this ID already exists")
+
 // Chat_init initializes the chat service
 func Chat_init() {
 
@@ -35,13 +40,41 @@ func GetRoom(roomID string) (*Room, bool) {
 // }
 
 func CreateRoom(roomID string, roomInfo *models.RoomInfo) (*Room, error) {
-	room := manager.CreateRoom(roomID, roomInfo.Name, roomInfo.CreatorID, roomInfo.IsPrivate)
+	// Direct rooms are only made by sending a direct message
+	if roomInfo.RoomType == RoomTypeDirect {
+		return nil, errDirectRoom
+	}
+
+	// The room may have been created on another node and not be loaded here yet
+	if stored, err := db.Valkey.RoomExists(roomID); err != nil {
+		return nil, err
+	} else if stored {
+		return nil, ErrRoomExists
+	}
+
+	room, err := manager.CreateRoom(roomID, roomInfo.Name, roomInfo.CreatorID, roomInfo.IsPrivate)
+	if err != nil {
+		return nil, err
+	}
+
+	room.mutex.Lock()
+	room.RoomType = roomInfo.RoomType
+	room.AllowReplies = roomInfo.AllowReplies
+	room.mutex.Unlock()
 	return room, nil
 }
 
+// discardRoom takes back a room whose creation failed half way, with whatever of it was stored
+func discardRoom(room *Room) {
+	if err := db.Valkey.DeleteRoom(room.ID, room.Members()); err != nil {
+		log.Printf("Error removing stored data of room %s: %v", room.ID, err)
+	}
+	manager.removeRoom(room)
+}
+
 // JoinRoom adds a user to a room if they are authorized
 func JoinRoom(roomID, userID string) bool {
-	return manager.JoinRoom(roomID, userID)
+	return manager.JoinRoom(roomID, userID) == nil
 }
 
 // LeaveRoom removes a user from a room's active members
@@ -76,9 +109,12 @@ func HandleWebSocketConnection(userID, userName string, conn *websocket.Conn) {
 	client := NewClient(userID, userName, conn, manager)
 
 	// Register the client with the manager
-	manager.Register <- client
+	manager.Register(client)
 
 	// Start the client's read and write pumps
 	go client.WritePump()
 	go client.ReadPump()
+
+	// Deliver what the user missed while they were away
+	manager.flushQueue(client)
 }
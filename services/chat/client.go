@@ -2,9 +2,13 @@ package chat
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	db "raychat/database"
 	"raychat/models"
 
 	"github.com/google/uuid"
@@ -28,32 +32,52 @@ const (
 )
 
 type Client struct {
-	// ID       string
+	ID       string // identifies the connection, a user may have several across nodes
 	UserID   string
 	UserName string
-	Conn     *websocket.Conn
+	Conn     *websocket.Conn // nil for clients connected over gRPC
 	Manager  *ChatManager
 	Send     chan *models.Message
-	Rooms    map[string]bool
+	Rooms    map[string]bool // rooms the client is active in, kept up to date by the rooms
+
+	handleMutex sync.Mutex      // serializes HandleMessage, gRPC calls can arrive concurrently
+	rejection   *models.Message // first notice refusing the message being handled
+	limitMutex  sync.Mutex      // guards limiter and offenses
+	limiter     *TokenBucket    // inbound messages of this connection, created with the first one
+	offenses    []time.Time     // recent rate limited messages
+	roomsMutex  sync.Mutex      // guards Rooms
+	sendMutex   sync.RWMutex    // lets Send be closed while other goroutines deliver to it
+	closed      bool
+
+	disconnected atomic.Bool // the connection stopped counting towards the user's presence
+
+	overflowMutex sync.Mutex  // guards the fields below, set when Send overflows
+	gap           *models.Gap // messages dropped since the last "gap" notice
+	closeCode     int
+	closeText     string
+
+	replayMutex sync.Mutex
+	replays     map[string][]*models.Message // live messages held back per room while it is replayed
 }
 
 // NewClient creates a new chat client
 func NewClient(userID, userName string, conn *websocket.Conn, manager *ChatManager) *Client {
 	return &Client{
-		// ID:       userID,
+		ID:       uuid.New().String(),
 		UserID:   userID,
 		UserName: userName,
 		Conn:     conn,
 		Manager:  manager,
 		Send:     make(chan *models.Message, 256),
 		Rooms:    make(map[string]bool),
+		replays:  make(map[string][]*models.Message),
 	}
 }
 
 // ReadPump pumps messages from the WebSocket connection to the hub
 func (c *Client) ReadPump() {
 	defer func() {
-		c.Manager.Unregister <- c
+		c.Manager.Unregister(c)
 		c.Conn.Close()
 		log.Printf("Client disconnected: %s", c.UserID)
 	}()
@@ -62,6 +86,7 @@ func (c *Client) ReadPump() {
 	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.Manager.presence.Seen(c.UserID)
 		return nil
 	})
 
@@ -82,122 +107,223 @@ func (c *Client) ReadPump() {
 			continue
 		}
 
-		//Set sender ID if not already set
-		if msg.SenderID == "" {
-			msg.SenderID = c.UserID
-		}
+		c.HandleMessage(&msg)
+	}
+}
+
+// Rejection is returned by HandleMessage for a message the server refused, the client was sent Notice
+type Rejection struct {
+	Notice *models.Message // an "error", "rate_limited" or "room_full" message
+}
+
+func (r *Rejection) Error() string {
+	return r.Notice.Content
+}
+
+// HandleMessage processes a message received from the client, whatever transport it came over.
+// A refused message is answered with a notice on Send and returned as a *Rejection
+func (c *Client) HandleMessage(msg *models.Message) error {
+	c.handleMutex.Lock()
+	defer c.handleMutex.Unlock()
+
+	c.rejection = nil
+	c.handleMessage(msg)
+	if c.rejection != nil {
+		return &Rejection{Notice: c.rejection}
+	}
+	return nil
+}
 
-		// Set timestamp if not already set
-		if msg.Timestamp == 0 {
-			msg.Timestamp = time.Now().Unix()
+// reject sends the client the notice refusing its message, it runs within HandleMessage
+func (c *Client) reject(notice *models.Message) {
+	if c.rejection == nil {
+		c.rejection = notice
+	}
+	sendToClient(c, notice)
+}
+
+func (c *Client) handleMessage(msg *models.Message) {
+	if !c.allowMessage(msg) {
+		return
+	}
+
+	c.Manager.presence.Active(c.UserID)
+
+	// The sender and the time are always the server's, whatever the client sent
+	msg.SenderID = c.UserID
+	msg.Timestamp = time.Now().Unix()
+
+	log.Printf("Processing message: type=%s, room=%s, sender=%s, content=%s",
+		msg.Type, msg.RoomID, msg.SenderID, msg.Content)
+
+	switch msg.Type {
+	case "join":
+		// A reconnecting client sends the last sequence number it saw to get the messages it missed
+		var req models.JoinRequest
+		if len(msg.Payload) > 0 {
+			if err := json.Unmarshal(msg.Payload, &req); err != nil {
+				c.reject(NewMessage(msg.RoomID, "system", "Invalid join request", "error"))
+				return
+			}
+		}
+		if req.LastSeq > 0 {
+			c.beginReplay(msg.RoomID)
 		}
 
-		log.Printf("Processing message: type=%s, room=%s, sender=%s, content=%s",
-			msg.Type, msg.RoomID, msg.SenderID, msg.Content)
-
-		switch msg.Type {
-		case "join":
-			//join the room
-			if c.Manager.JoinRoom(msg.RoomID, c.UserID) {
-				c.Rooms[msg.RoomID] = true
-
-				//Notify other members
-				joinMsg := &models.Message{
-					ID:        uuid.New().String(),
-					RoomID:    msg.RoomID,
-					SenderID:  c.UserID,
-					Content:   c.UserName + " joined the room",
-					Type:      "system",
-					Timestamp: time.Now().Unix(),
-				}
-				c.Manager.Broadcast <- joinMsg
-			} else {
-				// Failure case - send error message back to this client only
-				errorMsg := &models.Message{
-					ID:        uuid.New().String(),
-					RoomID:    msg.RoomID,
-					SenderID:  "system",
-					Content:   "You are not authorized to join this room",
-					Type:      "error",
-					Timestamp: time.Now().Unix(),
-				}
+		//join the room
+		err := c.Manager.JoinRoom(msg.RoomID, c.UserID)
+		if err == nil {
+			//Notify other members
+			joinMsg := &models.Message{
+				ID:        uuid.New().String(),
+				RoomID:    msg.RoomID,
+				SenderID:  c.UserID,
+				Content:   c.UserName + " joined the room",
+				Type:      "system",
+				Timestamp: time.Now().Unix(),
+			}
+			c.Manager.Broadcast(joinMsg)
 
-				// Marshal the message to JSON
-				errorData, err := json.Marshal(errorMsg)
-				if err != nil {
-					log.Printf("Error marshaling error message: %v", err)
-					return
-				}
+			if req.LastSeq > 0 {
+				c.replayRoom(msg.RoomID, req.LastSeq)
+			}
+		} else {
+			if req.LastSeq > 0 {
+				c.endReplay(msg.RoomID, 0)
+			}
 
-				// Send directly to this client's connection
-				if err := c.Conn.WriteMessage(websocket.TextMessage, errorData); err != nil {
-					log.Printf("Error sending error message: %v", err)
-				}
+			if errors.Is(err, ErrRoomFull) {
+				sendRoomFull(c, msg.RoomID)
+				return
+			}
 
-				log.Printf("User %s attempted to join room %s but was unauthorized", c.UserID, msg.RoomID)
+			// Failure case - send error message back to this client only
+			content := "You are not authorized to join this room"
+			if room, exists := c.Manager.GetRoom(msg.RoomID); exists && room.IsPrivate && !room.IsAuthorized(c.UserID) {
+				content += ", send a join_request to ask its admins to let you in"
+			}
+			errorMsg := &models.Message{
+				ID:        uuid.New().String(),
+				RoomID:    msg.RoomID,
+				SenderID:  "system",
+				Content:   content,
+				Type:      "error",
+				Timestamp: time.Now().Unix(),
 			}
 
-		case "leave":
-			if _, exists := c.Rooms[msg.RoomID]; exists {
-				delete(c.Rooms, msg.RoomID)
-
-				if room, exists := c.Manager.GetRoom(msg.RoomID); exists {
-					c.Manager.mutex.Lock()
-					delete(room.ActiveMembers, c.UserID)
-					c.Manager.mutex.Unlock()
-					// Notify other members
-					leaveMsg := &models.Message{
-						ID:        uuid.New().String(),
-						RoomID:    msg.RoomID,
-						SenderID:  c.UserID,
-						Content:   c.UserName + " left the room",
-						Type:      "system",
-						Timestamp: time.Now().Unix(),
-					}
-					c.Manager.Broadcast <- leaveMsg
-				}
+			c.reject(errorMsg)
+
+			log.Printf("User %s attempted to join room %s but was unauthorized", c.UserID, msg.RoomID)
+		}
+
+	case "leave":
+		if c.Manager.LeaveRoom(msg.RoomID, c.UserID) {
+			// Notify other members
+			leaveMsg := &models.Message{
+				ID:        uuid.New().String(),
+				RoomID:    msg.RoomID,
+				SenderID:  c.UserID,
+				Content:   c.UserName + " left the room",
+				Type:      "system",
+				Timestamp: time.Now().Unix(),
 			}
+			c.Manager.Broadcast(leaveMsg)
+		}
 
-		case "message":
-
-			room, exists := c.Manager.GetRoom(msg.RoomID)
-			if !exists {
-				// Room doesn't exist
-				errorMsg := &models.Message{
-					ID:        uuid.New().String(),
-					RoomID:    msg.RoomID,
-					SenderID:  "system",
-					Content:   "Room does not exist",
-					Type:      "error",
-					Timestamp: time.Now().Unix(),
-				}
-				sendErrorToClient(c, errorMsg)
-				continue
+	case "message":
+
+		room, exists := c.Manager.GetRoom(msg.RoomID)
+		if !exists {
+			// Room doesn't exist
+			errorMsg := &models.Message{
+				ID:        uuid.New().String(),
+				RoomID:    msg.RoomID,
+				SenderID:  "system",
+				Content:   "Room does not exist",
+				Type:      "error",
+				Timestamp: time.Now().Unix(),
 			}
-			// Check if user is an active member of the room
-			c.Manager.mutex.RLock()
-			isActiveMember := false
-			if room.ActiveMembers != nil {
-				_, isActiveMember = room.ActiveMembers[c.UserID]
+			c.reject(errorMsg)
+			return
+		}
+		// Check if user is an active member of the room
+		if !room.IsActive(c.UserID) {
+			// User is not an active member of the room
+			errorMsg := &models.Message{
+				ID:        uuid.New().String(),
+				RoomID:    msg.RoomID,
+				SenderID:  "system",
+				Content:   "You must join the room before sending messages",
+				Type:      "error",
+				Timestamp: time.Now().Unix(),
 			}
-			c.Manager.mutex.RUnlock()
-
-			if !isActiveMember {
-				// User is not an active member of the room
-				errorMsg := &models.Message{
-					ID:        uuid.New().String(),
-					RoomID:    msg.RoomID,
-					SenderID:  "system",
-					Content:   "You must join the room before sending messages",
-					Type:      "error",
-					Timestamp: time.Now().Unix(),
-				}
-				sendErrorToClient(c, errorMsg)
-				continue
+			c.reject(errorMsg)
+			return
+		}
+		if !c.checkCanPost(msg.RoomID, room.postPermission(msg.ParentID)) {
+			return
+		}
+
+		// A reply must answer a top-level message of the same room
+		var parent *models.Message
+		if msg.ParentID != "" {
+			var err error
+			if parent, err = loadThreadParent(msg.RoomID, msg.ParentID); err != nil {
+				c.reject(NewMessage(msg.RoomID, "system", err.Error(), "error"))
+				return
 			}
-			// Regular message, broadcast to room
-			c.Manager.Broadcast <- &msg
 		}
+
+		// Stored messages get a server-generated ID so clients can't overwrite each other's
+		msg.ID = uuid.New().String()
+		msg.ExpiresAt = room.expiryFor(msg.Timestamp)
+
+		// Store the message so it can be fetched later with "history"
+		// A message that isn't stored would be missing from history and replays, so it isn't sent
+		if err := db.SaveMessage(msg); err != nil {
+			log.Printf("Error storing message %s: %v", msg.ID, err)
+			c.reject(NewMessage(msg.RoomID, "system", "Unable to send message", "error"))
+			return
+		}
+
+		if parent != nil {
+			c.Manager.threadReplied(parent, msg)
+		}
+
+		c.Manager.notifyMentions(msg)
+
+		// Sending a message ends the sender's typing indicator
+		c.Manager.typing.Stop(msg.RoomID, c.UserID)
+
+		// Regular message, broadcast to room
+		c.Manager.Broadcast(msg)
+
+	case "history":
+		c.handleHistory(msg)
+
+	case "dm":
+		c.handleDirectMessage(msg)
+
+	case "typing_start", "typing_stop":
+		c.handleTyping(msg)
+
+	case "delivered", "read":
+		c.handleReceipt(msg)
+
+	case "edit", "delete":
+		c.handleMessageChange(msg)
+
+	case "reaction":
+		c.handleReaction(msg)
+
+	case "thread":
+		c.handleThread(msg)
+
+	case ActionMute, ActionUnmute, ActionKick, ActionBan, ActionUnban:
+		c.handleModeration(msg)
+
+	case "join_request", "join_approve", "join_deny":
+		c.handleJoinRequest(msg)
 	}
 }
 
@@ -215,7 +341,11 @@ func (c *Client) WritePump() {
 			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				closeMessage := []byte{}
+				if code, text := c.closeReason(); code != 0 {
+					closeMessage = websocket.FormatCloseMessage(code, text)
+				}
+				c.Conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
 
@@ -232,9 +362,18 @@ func (c *Client) WritePump() {
 			w.Write(data)
 
 			// Add queued messages to the current websocket message
+			// The drop_oldest policy may take some of them meanwhile, don't wait for those
 			n := len(c.Send)
 			for i := 0; i < n; i++ {
-				nextMsg := <-c.Send
+				var nextMsg *models.Message
+				select {
+				case nextMsg = <-c.Send:
+				default:
+				}
+				if nextMsg == nil {
+					break
+				}
+
 				data, err := json.Marshal(nextMsg)
 				if err != nil {
 					continue
@@ -256,15 +395,65 @@ func (c *Client) WritePump() {
 	}
 }
 
-// Helper function to send error messages directly to a client
-func sendErrorToClient(c *Client, msg *models.Message) {
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("Error marshaling error message: %v", err)
-		return
+// Helper function to send messages directly to a client
+// The message is queued on the client's Send channel so only the write pump touches the connection,
+// a full buffer is handled by the slow-consumer policy. It reports whether the message was queued
+func sendToClient(c *Client, msg *models.Message) bool {
+	c.sendMutex.RLock()
+	defer c.sendMutex.RUnlock()
+
+	if c.closed {
+		return false
 	}
 
-	if err := c.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
-		log.Printf("Error sending error message: %v", err)
+	// Messages dropped earlier are reported before anything newer
+	if !c.flushGap() {
+		return c.overflowPolicy().Overflow(c, msg)
+	}
+
+	select {
+	case c.Send <- msg:
+		return true
+	default:
+		return c.overflowPolicy().Overflow(c, msg)
+	}
+}
+
+// closeSend closes the Send channel once, later deliveries are dropped
+func (c *Client) closeSend() {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.Send)
+	}
+}
+
+// addRoom records that the client is active in the room
+func (c *Client) addRoom(roomID string) {
+	c.roomsMutex.Lock()
+	defer c.roomsMutex.Unlock()
+
+	c.Rooms[roomID] = true
+}
+
+// removeRoom records that the client left the room
+func (c *Client) removeRoom(roomID string) {
+	c.roomsMutex.Lock()
+	defer c.roomsMutex.Unlock()
+
+	delete(c.Rooms, roomID)
+}
+
+// roomIDs returns the rooms the client is active in
+func (c *Client) roomIDs() []string {
+	c.roomsMutex.Lock()
+	defer c.roomsMutex.Unlock()
+
+	roomIDs := make([]string, 0, len(c.Rooms))
+	for roomID := range c.Rooms {
+		roomIDs = append(roomIDs, roomID)
 	}
+	return roomIDs
 }
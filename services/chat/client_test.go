@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"database/sql"
+	db "raychat/database"
+	"raychat/models"
+	"testing"
+	"time"
+)
+
+// Whatever a client claims, its messages carry its own user ID and the server's time
+func TestHandleMessageSetsSender(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  models.Message
+	}{
+		{"spoofed sender", models.Message{SenderID: "mallory", Type: "message"}},
+		{"system sender", models.Message{SenderID: "system", Type: "message"}},
+		{"no sender", models.Message{Type: "message"}},
+		{"backdated", models.Message{SenderID: "alice", Timestamp: 1, Type: "message"}},
+		{"future", models.Message{SenderID: "alice", Timestamp: time.Now().Add(time.Hour).Unix(), Type: "message"}},
+		{"unknown type", models.Message{SenderID: "mallory", Type: "bogus"}},
+	}
+
+	// Rate limits go to Valkey, a failing one lets messages through
+	useFakeValkey(t, nil)
+
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client), rateLimits: loadRateLimits()}
+	cm.presence = NewPresenceService(cm)
+	client := NewClient("alice", "Alice", nil, cm)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The room doesn't exist, so the message stops at the error sent back
+			msg := tt.msg
+			msg.RoomID = "nowhere"
+
+			before := time.Now().Unix()
+			client.HandleMessage(&msg)
+
+			if msg.SenderID != "alice" {
+				t.Errorf("SenderID = %q, want alice", msg.SenderID)
+			}
+			if msg.Timestamp < before || msg.Timestamp > time.Now().Unix() {
+				t.Errorf("Timestamp = %d, want the time it was handled", msg.Timestamp)
+			}
+		})
+	}
+}
+
+// A message that can't be stored is refused, nothing is broadcast
+func TestHandleMessageUnsaved(t *testing.T) {
+	useFakeValkey(t, nil)
+
+	// Storing the message fails
+	useUnreachablePostgres(t)
+
+	room := NewRoom("lounge", "Lounge", "alice", false)
+	// No bus, a broadcast would panic
+	cm := &ChatManager{Rooms: map[string]*Room{room.ID: room}, Clients: make(map[string]*Client), rateLimits: loadRateLimits()}
+	cm.presence = NewPresenceService(cm)
+	client := NewClient("alice", "Alice", nil, cm)
+	room.ActiveMembers[client.UserID] = client
+
+	client.HandleMessage(&models.Message{RoomID: room.ID, Type: "message", Content: "hi"})
+
+	select {
+	case msg := <-client.Send:
+		if msg.Type != "error" {
+			t.Errorf("sent %q, want an error", msg.Type)
+		}
+	default:
+		t.Fatal("no error sent back")
+	}
+}
+
+// useUnreachablePostgres points db.PostgresDB at an address nothing listens on until the
+// test ends, so every query fails instead of panicking on a nil database
+func useUnreachablePostgres(t *testing.T) {
+	t.Helper()
+
+	postgres, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+
+	previous := db.PostgresDB
+	db.PostgresDB = postgres
+	t.Cleanup(func() {
+		db.PostgresDB = previous
+		postgres.Close()
+	})
+}
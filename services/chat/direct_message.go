@@ -0,0 +1,177 @@
+package chat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RoomTypeDirect is the type of the private room two users exchange direct messages in
+const RoomTypeDirect = "dm"
+
+// errDirectRoom is returned when a direct room is moderated or managed, it has no owner or admins
+var errDirectRoom = errors.New("Direct message rooms can't be managed")
+
+// directPermissions are the only permissions held in a direct room, by both of its members
+var directPermissions = []Permission{PermRead, PermPost, PermReact}
+
+// newDirectRoom creates the room of a user pair, both are plain members and neither owns it
+func newDirectRoom(roomID, userA, userB string) *Room {
+	room := NewRoom(roomID, "", "", true)
+	room.RoomType = RoomTypeDirect
+	room.AuthorizedMembers = map[string]bool{userA: true, userB: true}
+	room.Admins = make(map[string]bool)
+	return room
+}
+
+// IsDirect reports whether the room is the direct room of a user pair
+func (r *Room) IsDirect() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.RoomType == RoomTypeDirect
+}
+
+// checkNotDirect rejects moderating or managing a direct room
+func checkNotDirect(room *Room) error {
+	if room.IsDirect() {
+		return errDirectRoom
+	}
+	return nil
+}
+
+// directRoomID returns the ID of the private room shared by two users, the same whichever of them asks
+// The pair is hashed length-prefixed, so no two pairs share an ID whatever their user IDs hold
+func directRoomID(userA, userB string) string {
+	users := []string{userA, userB}
+	sort.Strings(users)
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s%d:%s", len(users[0]), users[0], len(users[1]), users[1])))
+	return "dm-" + hex.EncodeToString(sum[:16])
+}
+
+// legacyDirectRoomID returns the ID direct rooms were created with before, pairs whose
+// user IDs hold a "-" could end up with the same one
+func legacyDirectRoomID(userA, userB string) string {
+	users := []string{userA, userB}
+	sort.Strings(users)
+	return "dm-" + strings.Join(users, "-")
+}
+
+// isDirectPair reports whether the room is the direct room of exactly these two users
+func (r *Room) isDirectPair(userA, userB string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.RoomType == RoomTypeDirect && len(r.AuthorizedMembers) == 2 &&
+		r.AuthorizedMembers[userA] && r.AuthorizedMembers[userB]
+}
+
+// GetOrCreateDirectRoom returns the private two-member room of a user pair, creating it on first use
+func (cm *ChatManager) GetOrCreateDirectRoom(senderID, recipientID string) (*Room, error) {
+	roomID := directRoomID(senderID, recipientID)
+
+	// The room may have been created by another node
+	cm.syncRoom(roomID, senderID)
+	if room, exists := cm.GetRoom(roomID); exists {
+		return room, nil
+	}
+
+	// A pair who talked before keeps their room, as long as it is theirs alone
+	legacyID := legacyDirectRoomID(senderID, recipientID)
+	cm.syncRoom(legacyID, senderID)
+	if room, exists := cm.GetRoom(legacyID); exists && room.isDirectPair(senderID, recipientID) {
+		return room, nil
+	}
+
+	created := newDirectRoom(roomID, senderID, recipientID)
+	room := cm.addRoom(created)
+
+	if room != created {
+		return room, nil
+	}
+
+	roomInfo := models.RoomInfo{
+		RoomType:  RoomTypeDirect,
+		IsPrivate: true,
+		Timestamp: room.CreatedAt,
+	}
+	if err := StoreRoomInValkey(roomID, roomInfo); err != nil {
+		return nil, err
+	}
+
+	// Index the room for both users and authorize them
+	for _, userID := range []string{senderID, recipientID} {
+		if err := db.Valkey.AddUserToRoom(userID, roomID); err != nil {
+			return nil, fmt.Errorf("failed to add user %s to direct room: %w", userID, err)
+		}
+	}
+
+	log.Printf("Created direct room %s for %s and %s", roomID, senderID, recipientID)
+	return room, nil
+}
+
+// joinDirectRoom makes the client an active member of a direct room it belongs to
+func (cm *ChatManager) joinDirectRoom(client *Client, roomID string) {
+	cm.syncRoom(roomID, client.UserID)
+
+	room, exists := cm.GetRoom(roomID)
+	if !exists || room.IsActive(client.UserID) {
+		return
+	}
+
+	room.Activate(client)
+}
+
+// handleDirectMessage stores a "dm" in the pair's direct room and delivers it to both users
+func (c *Client) handleDirectMessage(msg *models.Message) {
+	recipientID := msg.ReceiverID
+	if recipientID == "" || recipientID == c.UserID {
+		c.reject(NewMessage("", "system", "A direct message needs another user as receiver", "error"))
+		return
+	}
+
+	exists, err := db.Valkey.UserExists(recipientID)
+	if err != nil || !exists {
+		c.reject(NewMessage("", "system", "Recipient does not exist", "error"))
+		return
+	}
+
+	room, err := c.Manager.GetOrCreateDirectRoom(c.UserID, recipientID)
+	if err != nil {
+		log.Printf("Error creating direct room for %s and %s: %v", c.UserID, recipientID, err)
+		c.reject(NewMessage("", "system", "Unable to send direct message", "error"))
+		return
+	}
+
+	dm := &models.Message{
+		ID:         uuid.New().String(),
+		RoomID:     room.ID,
+		SenderID:   c.UserID,
+		ReceiverID: recipientID,
+		Content:    msg.Content,
+		Type:       "dm",
+		Timestamp:  time.Now().Unix(),
+	}
+	dm.ExpiresAt = room.expiryFor(dm.Timestamp)
+
+	if err := db.SaveMessage(dm); err != nil {
+		log.Printf("Error storing direct message %s: %v", dm.ID, err)
+		c.reject(NewMessage("", "system", "Unable to send direct message", "error"))
+		return
+	}
+
+	// Deliver through the user channels, the sender gets its copy like in a room
+	// An offline recipient finds the message in their queue on reconnect
+	c.Manager.sendOrQueue(recipientID, dm)
+	c.Manager.SendToUser(&UserMessage{UserID: c.UserID, Message: dm})
+}
@@ -0,0 +1,102 @@
+package chat
+
+import (
+	"errors"
+	"raychat/models"
+	"testing"
+)
+
+func TestDirectRoomPermissions(t *testing.T) {
+	room := newDirectRoom(directRoomID("alice", "bob"), "alice", "bob")
+
+	if owner := room.Owner(); owner != "" {
+		t.Errorf("Owner() = %q, want none", owner)
+	}
+
+	for _, userID := range []string{"alice", "bob"} {
+		if role := room.Role(userID); role != RoleMember {
+			t.Errorf("Role(%s) = %q, want %q", userID, role, RoleMember)
+		}
+
+		for _, permission := range ownerPermissions {
+			want := permission == PermRead || permission == PermPost || permission == PermReact
+			if got := room.Can(userID, permission); got != want {
+				t.Errorf("Can(%s, %s) = %v, want %v", userID, permission, got, want)
+			}
+		}
+	}
+
+	for _, permission := range ownerPermissions {
+		if room.Can("mallory", permission) {
+			t.Errorf("Can(mallory, %s) = true for a stranger", permission)
+		}
+	}
+}
+
+// Nothing about a direct room can be managed, whoever asks
+func TestDirectRoomRejectsManagement(t *testing.T) {
+	room := newDirectRoom(directRoomID("alice", "bob"), "alice", "bob")
+	cm := &ChatManager{Rooms: map[string]*Room{room.ID: room}, Clients: make(map[string]*Client)}
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"moderate", func() error {
+			_, err := cm.Moderate(room.ID, "alice", models.ModerationRequest{UserID: "bob", Action: ActionBan})
+			return err
+		}},
+		{"invite", func() error {
+			_, err := cm.CreateInvite(room.ID, "alice", models.CreateInviteRequest{})
+			return err
+		}},
+		{"request to join", func() error {
+			_, err := cm.RequestToJoin(room.ID, "alice", "")
+			return err
+		}},
+		{"decide join request", func() error { return cm.DecideJoinRequest(room.ID, "alice", "carol", true) }},
+		{"define role", func() error { return cm.DefineRole(room.ID, "alice", "helper", []string{string(PermRead)}) }},
+		{"delete role", func() error { return cm.DeleteRole(room.ID, "alice", RoleMember) }},
+		{"assign role", func() error { return cm.AssignRole(room.ID, "alice", "bob", RoleAdmin) }},
+		{"archive", func() error { return cm.SetArchived(room.ID, "alice", true) }},
+		{"transfer", func() error { return cm.TransferOwnership(room.ID, "alice", "bob") }},
+		{"delete", func() error { return cm.DeleteRoom(room.ID, "alice") }},
+		{"retention", func() error { return cm.SetMessageTTL(room.ID, "alice", 3600) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, errDirectRoom) {
+				t.Errorf("err = %v, want %v", err, errDirectRoom)
+			}
+		})
+	}
+}
+
+func TestDirectRoomID(t *testing.T) {
+	if directRoomID("alice", "bob") != directRoomID("bob", "alice") {
+		t.Error("the pair's room depends on who asks")
+	}
+
+	// Pairs the legacy ID mixed up
+	pairs := [][2]string{{"a-b", "c"}, {"a", "b-c"}, {"a", "b"}, {"a-", "b"}, {"a", "-b"}, {"1:a", "b"}, {"1", "a:b"}}
+	seen := make(map[string][2]string)
+	for _, pair := range pairs {
+		id := directRoomID(pair[0], pair[1])
+		if other, exists := seen[id]; exists {
+			t.Errorf("%v and %v share room %s", pair, other, id)
+		}
+		seen[id] = pair
+	}
+}
+
+func TestIsDirectPair(t *testing.T) {
+	room := newDirectRoom(legacyDirectRoomID("a-b", "c"), "a-b", "c")
+
+	if !room.isDirectPair("c", "a-b") {
+		t.Error("the room isn't its own pair's")
+	}
+	if room.isDirectPair("a", "b-c") {
+		t.Error("a pair with the same legacy ID got the room")
+	}
+}
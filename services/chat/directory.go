@@ -0,0 +1,141 @@
+package chat
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"strings"
+)
+
+const (
+	// Number of rooms listed when the client does not ask for a limit
+	defaultDirectoryLimit = 20
+
+	// Upper bound on the number of rooms listed in one page
+	maxDirectoryLimit = 100
+
+	// Directory entries read from Valkey at a time while filtering a search
+	directoryScanBatch = 200
+
+	// Most entries a search looks through for one page, the rest is left to the next cursor
+	maxDirectoryScan = 2000
+)
+
+// isListed reports whether the room belongs in the public directory
+func isListed(isPrivate bool, roomType string) bool {
+	return !isPrivate && roomType != RoomTypeDirect
+}
+
+// indexRoom lists a public room in the directory
+func indexRoom(roomID, name, roomType string, isPrivate bool) {
+	if !isListed(isPrivate, roomType) {
+		return
+	}
+
+	if err := db.Valkey.IndexPublicRoom(roomID, name, roomType); err != nil {
+		log.Printf("Error indexing room %s: %v", roomID, err)
+	}
+}
+
+// unindexRoom removes the room from the directory
+func unindexRoom(room *Room) {
+	if !isListed(room.IsPrivate, room.RoomType) {
+		return
+	}
+
+	if err := db.Valkey.UnindexPublicRoom(room.ID, room.Name, room.RoomType); err != nil {
+		log.Printf("Error unindexing room %s: %v", room.ID, err)
+	}
+}
+
+// ListPublicRooms returns a page of the public rooms whose name or description contains
+// the query, of the given type unless it is empty, ordered by name
+func (cm *ChatManager) ListPublicRooms(query, roomType, cursor string, limit int) (*models.DirectoryPage, error) {
+	if limit <= 0 {
+		limit = defaultDirectoryLimit
+	} else if limit > maxDirectoryLimit {
+		limit = maxDirectoryLimit
+	}
+
+	after := ""
+	if cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor: %q", cursor)
+		}
+		after = string(decoded)
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	batch := limit + 1 // One past the page tells whether there is a next one
+	if query != "" {
+		batch = directoryScanBatch
+	}
+
+	page := &models.DirectoryPage{Rooms: make([]models.DirectoryRoom, 0, limit)}
+	for scanned := 0; scanned < maxDirectoryScan; {
+		entries, err := db.Valkey.ScanPublicRooms(roomType, after, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			return page, nil
+		}
+		scanned += len(entries)
+
+		roomIDs := make([]string, len(entries))
+		for i, entry := range entries {
+			roomIDs[i] = db.DirectoryRoomID(entry)
+		}
+
+		rooms, err := db.Valkey.GetDirectoryRooms(roomIDs)
+		if err != nil {
+			return nil, err
+		}
+		listed := make(map[string]models.DirectoryRoom, len(rooms))
+		for _, room := range rooms {
+			listed[room.ID] = room
+		}
+
+		more := false
+		for i, entry := range entries {
+			room, exists := listed[roomIDs[i]]
+			if !exists || !matchesQuery(room, query) {
+				after = entry
+				continue
+			}
+
+			// The page is full and another room follows, the next page starts after the last one listed
+			if len(page.Rooms) == limit {
+				more = true
+				break
+			}
+
+			after = entry
+			page.Rooms = append(page.Rooms, room)
+		}
+
+		if more {
+			break
+		}
+		if len(entries) < batch {
+			return page, nil
+		}
+	}
+
+	// Either more rooms follow or the search ran out of scanning before the end of the directory
+
+	page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(after))
+	return page, nil
+}
+
+func matchesQuery(room models.DirectoryRoom, query string) bool {
+	if query == "" {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(room.Name), query) ||
+		strings.Contains(strings.ToLower(room.Description), query)
+}
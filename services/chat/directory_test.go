@@ -0,0 +1,159 @@
+package chat
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// useFakeDirectory lists the rooms, by ID to name and description, in a fake Valkey directory
+// Rooms with an empty name are indexed but no longer exist
+func useFakeDirectory(t *testing.T, rooms map[string][2]string) *fakeValkey {
+	t.Helper()
+
+	entries := make([]string, 0, len(rooms))
+	for roomID, room := range rooms {
+		entries = append(entries, strings.ToLower(room[0])+"\x00"+roomID)
+	}
+	sort.Strings(entries)
+
+	return useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+		// zrangebylex key min max limit offset count
+		"zrangebylex": func(args []interface{}) (interface{}, error) {
+			min := args[2].(string)
+			count := int(args[6].(int64))
+
+			page := make([]string, 0, count)
+			for _, entry := range entries {
+				if min != "-" && entry <= strings.TrimPrefix(min, "(") {
+					continue
+				}
+				if len(page) == count {
+					break
+				}
+				page = append(page, entry)
+			}
+			return page, nil
+		},
+		"hmget": func(args []interface{}) (interface{}, error) {
+			room := rooms[strings.TrimPrefix(args[1].(string), "chat:room:")]
+			if room[0] == "" {
+				return []interface{}{nil, nil, nil, nil, nil}, nil
+			}
+			return []interface{}{room[0], room[1], "group", nil, nil}, nil
+		},
+		"scard":  func([]interface{}) (interface{}, error) { return int64(3), nil },
+		"zcount": func([]interface{}) (interface{}, error) { return int64(1), nil },
+	})
+}
+
+// pageIDs returns the IDs of the rooms listed on each page, following the cursors to the end
+func pageIDs(t *testing.T, cm *ChatManager, query string, limit int) [][]string {
+	t.Helper()
+
+	pages := make([][]string, 0)
+	cursor := ""
+	for {
+		page, err := cm.ListPublicRooms(query, "", cursor, limit)
+		if err != nil {
+			t.Fatalf("list after %q: %v", cursor, err)
+		}
+
+		ids := make([]string, len(page.Rooms))
+		for i, room := range page.Rooms {
+			ids[i] = room.ID
+		}
+		pages = append(pages, ids)
+
+		if page.NextCursor == "" {
+			return pages
+		}
+		if len(pages) > 10 {
+			t.Fatal("cursors never end")
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func equalPages(got, want [][]string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if strings.Join(got[i], ",") != strings.Join(want[i], ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// Following the cursors lists every room once, by name, and the last page has no cursor
+func TestListPublicRoomsPaging(t *testing.T) {
+	rooms := map[string][2]string{
+		"r1": {"Apples", ""},
+		"r2": {"Bees", ""},
+		"r3": {"Cats", ""},
+		"r4": {"Dogs", ""},
+		"r5": {"Eels", ""},
+	}
+
+	tests := []struct {
+		name  string
+		rooms map[string][2]string
+		limit int
+		want  [][]string
+	}{
+		{"uneven", rooms, 2, [][]string{{"r1", "r2"}, {"r3", "r4"}, {"r5"}}},
+		{"exact", rooms, 5, [][]string{{"r1", "r2", "r3", "r4", "r5"}}},
+		{"one short", rooms, 4, [][]string{{"r1", "r2", "r3", "r4"}, {"r5"}}},
+		{"empty", map[string][2]string{}, 2, [][]string{{}}},
+		{"deleted rooms", map[string][2]string{
+			"r1": {"Apples", ""},
+			"r2": {"", ""},
+			"r3": {"Cats", ""},
+		}, 1, [][]string{{"r1"}, {"r3"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeDirectory(t, tt.rooms)
+			cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client)}
+
+			if got := pageIDs(t, cm, "", tt.limit); !equalPages(got, tt.want) {
+				t.Errorf("pages %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A search lists only the rooms whose name or description matches, reading the directory in batches
+func TestListPublicRoomsSearch(t *testing.T) {
+	rooms := map[string][2]string{
+		"r1": {"Apples", "fruit talk"},
+		"r2": {"Bees", "honey"},
+		"r3": {"Cats", "FRUIT bats welcome"},
+		"r4": {"Dogs", ""},
+		"r5": {"Grapefruit", ""},
+	}
+	fake := useFakeDirectory(t, rooms)
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client)}
+
+	if got, want := pageIDs(t, cm, " Fruit ", 2), [][]string{{"r1", "r3"}, {"r5"}}; !equalPages(got, want) {
+		t.Errorf("pages %v, want %v", got, want)
+	}
+
+	scans := fake.matching(func(args []interface{}) bool { return args[0] == "zrangebylex" })
+	for _, args := range scans {
+		if count := args[6].(int64); count != directoryScanBatch {
+			t.Errorf("scanned %d entries at a time, want %d", count, directoryScanBatch)
+		}
+	}
+}
+
+// A cursor that isn't one the directory handed out is refused
+func TestListPublicRoomsInvalidCursor(t *testing.T) {
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client)}
+	if _, err := cm.ListPublicRooms("", "", "not base64!", 2); err == nil {
+		t.Error("listed rooms after an invalid cursor")
+	}
+}
@@ -0,0 +1,257 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	db "raychat/database"
+	"raychat/models"
+	"raychat/proto/chatpb"
+	"raychat/services/auth"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ChatGrpcServer bridges the ChatService gRPC API into the chat manager
+// A Connect stream registers a regular Client, so backend services can join rooms
+// and send messages the same way WebSocket users do
+type ChatGrpcServer struct {
+	chatpb.UnimplementedChatServiceServer
+	manager *ChatManager
+}
+
+// ServeGrpc starts the ChatService gRPC server on the given host and port, it blocks until the server stops
+// Every call needs the same bearer token as the REST routes, in the "authorization" metadata
+func ServeGrpc(host, port string) error {
+	lis, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%s: %w", host, port, err)
+	}
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(authUnary),
+		grpc.StreamInterceptor(authStream),
+	)
+	chatpb.RegisterChatServiceServer(server, &ChatGrpcServer{manager: manager})
+
+	log.Printf("gRPC server running at %s:%s", host, port)
+	return server.Serve(lis)
+}
+
+// Most users listed by GetOnlineUsers
+const maxOnlineUsers = 1000
+
+type grpcUserKey struct{}
+
+// authenticate checks the bearer token of a call and returns a context carrying its user
+func authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "Authorization metadata required")
+	}
+
+	userID, err := auth.ParseUserToken(strings.TrimPrefix(values[0], "Bearer "))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return context.WithValue(ctx, grpcUserKey{}, userID), nil
+}
+
+// grpcUser returns the user authenticated for the call
+func grpcUser(ctx context.Context) string {
+	userID, _ := ctx.Value(grpcUserKey{}).(string)
+	return userID
+}
+
+func authUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authenticatedStream hands the authenticated context to stream handlers
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func authStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// Connect registers the caller as a client and streams every message sent to it
+// A user already connected to this node is refused rather than replaced
+func (s *ChatGrpcServer) Connect(req *chatpb.ConnectRequest, stream chatpb.ChatService_ConnectServer) error {
+	userID := grpcUser(stream.Context())
+	if req.GetUuid() != "" && req.GetUuid() != userID {
+		return status.Error(codes.PermissionDenied, "uuid does not match the token")
+	}
+
+	userName := req.GetUsername()
+	if userName == "" {
+		userName = userID
+	}
+
+	client := NewClient(userID, userName, nil, s.manager)
+	if !s.manager.RegisterIfAbsent(client) {
+		return status.Error(codes.AlreadyExists, "user is already connected")
+	}
+	log.Printf("gRPC client connected: %s", client.UserID)
+
+	// Deliver what the user missed while they were away, the loop below drains Send meanwhile
+	go s.manager.flushQueue(client)
+
+	for {
+		select {
+		case message, ok := <-client.Send:
+			if !ok {
+				// The manager closed the channel
+				if code, text := client.closeReason(); code != 0 {
+					return status.Error(codes.ResourceExhausted, text)
+				}
+				return nil
+			}
+
+			if err := stream.Send(toProtoMessage(message)); err != nil {
+				s.manager.Unregister(client)
+				log.Printf("gRPC client disconnected: %s", client.UserID)
+				return err
+			}
+
+		case <-stream.Context().Done():
+			s.manager.Unregister(client)
+			log.Printf("gRPC client disconnected: %s", client.UserID)
+			return nil
+		}
+	}
+}
+
+// SendMessage handles a message from a connected gRPC client exactly like a WebSocket frame
+// A refused message fails the call with the notice the client was also sent on its stream
+func (s *ChatGrpcServer) SendMessage(ctx context.Context, req *chatpb.SendMessageRequest) (*chatpb.SendMessageResponse, error) {
+	userID := grpcUser(ctx)
+	if req.GetSenderUuid() != "" && req.GetSenderUuid() != userID {
+		return nil, status.Error(codes.PermissionDenied, "sender_uuid does not match the token")
+	}
+
+	client, exists := s.manager.GetClient(userID)
+	if !exists {
+		return nil, status.Error(codes.FailedPrecondition, "sender is not connected, call Connect first")
+	}
+
+	msgType := req.GetType()
+	if msgType == "" {
+		msgType = "message"
+	}
+
+	msg := &models.Message{
+		ID:         uuid.New().String(),
+		RoomID:     req.GetRoomId(),
+		SenderID:   client.UserID,
+		ReceiverID: req.GetReceiverUuid(),
+		TargetID:   req.GetTargetId(),
+		ParentID:   req.GetParentId(),
+		Content:    req.GetContent(),
+		Type:       msgType,
+		Timestamp:  time.Now().Unix(),
+		Payload:    req.GetPayload(),
+	}
+	if err := client.HandleMessage(msg); err != nil {
+		return nil, rejectionStatus(err)
+	}
+
+	return &chatpb.SendMessageResponse{
+		Delivered: true,
+		MessageId: msg.ID,
+		Timestamp: timestamppb.New(time.Unix(msg.Timestamp, 0)),
+	}, nil
+}
+
+// rejectionStatus maps the error HandleMessage returned to the status of the call
+func rejectionStatus(err error) error {
+	var rejection *Rejection
+	if !errors.As(err, &rejection) {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	switch rejection.Notice.Type {
+	case "rate_limited", "room_full":
+		return status.Error(codes.ResourceExhausted, rejection.Error())
+	default:
+		return status.Error(codes.FailedPrecondition, rejection.Error())
+	}
+}
+
+// GetOnlineUsers lists the users connected to any node, up to maxOnlineUsers of them
+func (s *ChatGrpcServer) GetOnlineUsers(ctx context.Context, req *chatpb.ConnectRequest) (*chatpb.OnlineUsersResponse, error) {
+	userIDs, err := db.Valkey.GetOnlineUsers(maxOnlineUsers)
+	if err != nil {
+		log.Printf("Error loading online users: %v", err)
+		return nil, status.Error(codes.Unavailable, "unable to load online users")
+	}
+
+	names, err := db.Valkey.GetUserNames(userIDs)
+	if err != nil {
+		log.Printf("Error loading names of online users: %v", err)
+		names = make(map[string]string)
+	}
+
+	users := make([]*chatpb.User, 0, len(userIDs))
+	for _, userID := range userIDs {
+		users = append(users, &chatpb.User{
+			Uuid:     userID,
+			Username: names[userID],
+			Online:   true,
+		})
+	}
+
+	return &chatpb.OnlineUsersResponse{Users: users}, nil
+}
+
+func toProtoMessage(msg *models.Message) *chatpb.Message {
+	protoMsg := &chatpb.Message{
+		Id:           msg.ID,
+		SenderUuid:   msg.SenderID,
+		ReceiverUuid: msg.ReceiverID,
+		Content:      msg.Content,
+		Timestamp:    timestamppb.New(time.Unix(msg.Timestamp, 0)),
+		RoomId:       msg.RoomID,
+		Type:         msg.Type,
+		Payload:      msg.Payload,
+		TargetId:     msg.TargetID,
+		Deleted:      msg.Deleted,
+		ParentId:     msg.ParentID,
+		Seq:          msg.Seq,
+	}
+
+	if msg.EditedAt != 0 {
+		protoMsg.EditedAt = timestamppb.New(time.Unix(msg.EditedAt, 0))
+	}
+
+	if msg.ExpiresAt != 0 {
+		protoMsg.ExpiresAt = timestamppb.New(time.Unix(msg.ExpiresAt, 0))
+	}
+
+	return protoMsg
+}
@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"raychat/proto/chatpb"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Refused messages fail the call with a status matching the notice
+func TestRejectionStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"error", &Rejection{Notice: NewMessage("lounge", "system", "Room does not exist", "error")}, codes.FailedPrecondition},
+		{"rate limited", &Rejection{Notice: NewMessage("lounge", "system", "Too many messages", "rate_limited")}, codes.ResourceExhausted},
+		{"room full", &Rejection{Notice: NewMessage("lounge", "system", ErrRoomFull.Error(), "room_full")}, codes.ResourceExhausted},
+		{"other", errors.New("boom"), codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(rejectionStatus(tt.err)); got != tt.want {
+				t.Errorf("code = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A message the server refuses is not reported as delivered
+func TestSendMessageRejected(t *testing.T) {
+	// Rate limits go to Valkey, a failing one lets messages through
+	useFakeValkey(t, nil)
+
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client), rateLimits: loadRateLimits()}
+	cm.presence = NewPresenceService(cm)
+	client := NewClient("alice", "Alice", nil, cm)
+	cm.Clients[client.UserID] = client
+	server := &ChatGrpcServer{manager: cm}
+
+	ctx := context.WithValue(context.Background(), grpcUserKey{}, "alice")
+	resp, err := server.SendMessage(ctx, &chatpb.SendMessageRequest{RoomId: "nowhere", Content: "hi"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("SendMessage = %v, %v, want FailedPrecondition", resp, err)
+	}
+
+	select {
+	case notice := <-client.Send:
+		if notice.Type != "error" {
+			t.Errorf("notice type = %q, want error", notice.Type)
+		}
+	default:
+		t.Error("no notice sent on the stream")
+	}
+}
+
+// Online users come from the connections counted in Valkey, whichever node holds them
+func TestGetOnlineUsers(t *testing.T) {
+	useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+		"zremrangebyscore": func([]interface{}) (interface{}, error) { return int64(0), nil },
+		"zrangebyscore":    func([]interface{}) (interface{}, error) { return []string{"alice", "bob"}, nil },
+		"type":             func([]interface{}) (interface{}, error) { return "hash", nil },
+		"hget": func(args []interface{}) (interface{}, error) {
+			return map[interface{}]string{"user:alice": "Alice", "user:bob": "Bob"}[args[1]], nil
+		},
+	})
+
+	// Nobody is connected to this node
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client)}
+	server := &ChatGrpcServer{manager: cm}
+
+	resp, err := server.GetOnlineUsers(context.Background(), &chatpb.ConnectRequest{})
+	if err != nil {
+		t.Fatalf("GetOnlineUsers: %v", err)
+	}
+
+	want := map[string]string{"alice": "Alice", "bob": "Bob"}
+	if len(resp.Users) != len(want) {
+		t.Fatalf("got %d users, want %d", len(resp.Users), len(want))
+	}
+	for _, user := range resp.Users {
+		if want[user.Uuid] != user.Username || !user.Online {
+			t.Errorf("user %q = %q online %v, want %q online", user.Uuid, user.Username, user.Online, want[user.Uuid])
+		}
+	}
+}
@@ -2,9 +2,13 @@
 package chat
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"raychat/models"
+	"raychat/services/auth"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -53,7 +57,21 @@ func CreateRoomHandle(c *gin.Context) {
 	roomData := req.Roominfo
 
 	//create a room
-	_, err := CreateRoom(roomID, &roomData)
+	room, err := CreateRoom(roomID, &roomData)
+	if errors.Is(err, ErrRoomExists) {
+		c.JSON(http.StatusConflict, models.CreateRoomResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, errDirectRoom) {
+		c.JSON(http.StatusBadRequest, models.CreateRoomResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.CreateRoomResponse{
 			Success: false,
@@ -66,6 +84,8 @@ func CreateRoomHandle(c *gin.Context) {
 	//store the room info in valkey
 	err = StoreRoomInValkey(roomID, roomData)
 	if err != nil {
+		// Other nodes only know rooms stored in Valkey, this one must not keep it either
+		discardRoom(room)
 		c.JSON(http.StatusInternalServerError, models.CreateRoomResponse{
 			Success: false,
 			Message: "Unable to store the Room info in Valkey, \nerror: %v " + err.Error(),
@@ -92,8 +112,7 @@ func AddUsertoRoom(c *gin.Context) {
 		return
 	}
 
-	err := AddAuthorizedMemberUnrestricted(req.RoomCode, req.UserID, "")
-	if err != nil {
+	if _, exists := GetRoom(req.RoomCode); !exists {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
 			"message": "Error add the user to the chat manager server",
@@ -101,9 +120,16 @@ func AddUsertoRoom(c *gin.Context) {
 		return
 	}
 
-	//add the user to the room
-	err = AddUserToRoomAuthMembers(req.RoomCode, req.UserID)
-	if err != nil {
+	//add the user to the room, unless it is full
+	err := AddUserToRoomAuthMembers(req.RoomCode, req.UserID)
+	if err == ErrRoomFull {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"code":    "room_full",
+			"message": err.Error(),
+		})
+		return
+	} else if err != nil {
 		log.Printf("Failed to add user to authorized members: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -112,6 +138,14 @@ func AddUsertoRoom(c *gin.Context) {
 		return
 	}
 
+	if err := AddAuthorizedMemberUnrestricted(req.RoomCode, req.UserID, ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Error add the user to the chat manager server",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Successfuly added user to the room",
@@ -119,6 +153,746 @@ func AddUsertoRoom(c *gin.Context) {
 
 }
 
+// HandleGetRoomHistory returns a page of the room's stored messages, oldest first
+func HandleGetRoomHistory(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.CanReadRoom(roomID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized for this room"})
+		return
+	}
+
+	page, err := GetRoomHistory(roomID, userID, c.Query("before"), limit)
+	if err != nil {
+		log.Printf("Error loading history for room %s: %v", roomID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load room history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// HandleGetPresence returns the presence of the users listed in the user_ids query parameter
+func HandleGetPresence(c *gin.Context) {
+	userIDs := make([]string, 0)
+	for _, userID := range strings.Split(c.Query("user_ids"), ",") {
+		if userID = strings.TrimSpace(userID); userID != "" {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	if len(userIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_ids is required"})
+		return
+	}
+	if len(userIDs) > maxPresenceUsers {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many user_ids"})
+		return
+	}
+
+	presences, err := GetPresence(userIDs)
+	if err != nil {
+		log.Printf("Error loading presence: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load presence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"presence": presences})
+}
+
+// HandleGetThreadReplies returns a page of the replies to a message, oldest first
+func HandleGetThreadReplies(c *gin.Context) {
+	roomID := c.Param("roomId")
+	messageID := c.Param("messageId")
+	userID := c.GetString("userUUID")
+
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	if !manager.CanReadRoom(roomID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized for this room"})
+		return
+	}
+
+	page, err := GetThreadReplies(roomID, messageID, userID, c.Query("before"), limit)
+	if err != nil {
+		log.Printf("Error loading thread %s: %v", messageID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Thread not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// HandleGetMessageRevisions returns the previous contents of an edited message
+func HandleGetMessageRevisions(c *gin.Context) {
+	roomID := c.Param("roomId")
+	messageID := c.Param("messageId")
+	userID := c.GetString("userUUID")
+
+	if !manager.CanReadRoom(roomID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized for this room"})
+		return
+	}
+
+	revisions, err := GetMessageRevisions(roomID, messageID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message_id": messageID,
+		"revisions":  revisions,
+	})
+}
+
+// HandleGetRoomReadState returns how far each member of the room has read
+func HandleGetRoomReadState(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.CanReadRoom(roomID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized for this room"})
+		return
+	}
+
+	state, err := GetRoomReadState(roomID, userID)
+	if err != nil {
+		log.Printf("Error loading read state for room %s: %v", roomID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load read state"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"room_id": roomID,
+		"members": state,
+	})
+}
+
+// HandleModerateRoom applies a mute, kick or ban, or lifts one, on behalf of a room admin
+func HandleModerateRoom(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	var req models.ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, moderationPermission(req.Action)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to " + req.Action + " users"})
+		return
+	}
+
+	moderation, err := manager.Moderate(roomID, userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, moderation)
+}
+
+// HandleGetRoomModeration lists the room's current mutes, timed kicks and bans
+func HandleGetRoomModeration(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermMute) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only room moderators can see moderation"})
+		return
+	}
+
+	moderation, err := GetRoomModeration(roomID, userID)
+	if err != nil {
+		log.Printf("Error loading moderation of room %s: %v", roomID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load moderation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"room_id":    roomID,
+		"moderation": moderation,
+	})
+}
+
+// HandleGetRoomRoles lists the room's roles, their permissions and the role of every member
+func HandleGetRoomRoles(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	roles, err := GetRoomRoles(roomID, userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to view this room"})
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+// HandleDefineRole creates a custom role or changes its permissions
+func HandleDefineRole(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	var req models.RoleDefinition
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermManageRoles) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to manage roles"})
+		return
+	}
+
+	if err := manager.DefineRole(roomID, userID, c.Param("role"), req.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role saved"})
+}
+
+// HandleDeleteRole drops a custom role
+func HandleDeleteRole(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermManageRoles) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to manage roles"})
+		return
+	}
+
+	if err := manager.DeleteRole(roomID, userID, c.Param("role")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+}
+
+// HandleAssignRole gives a member of the room a role
+func HandleAssignRole(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	var req models.RoleAssignment
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermManageRoles) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to manage roles"})
+		return
+	}
+
+	if err := manager.AssignRole(roomID, userID, c.Param("userId"), req.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role assigned"})
+}
+
+// HandleCreateInvite creates an invite to the room
+func HandleCreateInvite(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	var req models.CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermInvite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to invite members"})
+		return
+	}
+
+	invite, err := manager.CreateInvite(roomID, userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, invite)
+}
+
+// HandleListInvites lists the room's unexpired invites
+func HandleListInvites(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermInvite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to manage invites"})
+		return
+	}
+
+	invites, err := ListInvites(roomID, userID)
+	if err != nil {
+		log.Printf("Error loading invites of room %s: %v", roomID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load invites"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"room_id": roomID,
+		"invites": invites,
+	})
+}
+
+// HandleRevokeInvite deletes an invite to the room
+func HandleRevokeInvite(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermInvite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to manage invites"})
+		return
+	}
+
+	if err := manager.RevokeInvite(roomID, userID, c.Param("token")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked"})
+}
+
+// HandleRedeemInvite makes the caller a member of the room the invite is for
+func HandleRedeemInvite(c *gin.Context) {
+	userID := c.GetString("userUUID")
+
+	invite, err := manager.RedeemInvite(c.Param("token"), userID)
+	if err == ErrRoomFull {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "room_full"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Successfuly added user to the room",
+		"room_id": invite.RoomID,
+	})
+}
+
+// HandleRequestToJoin files the caller's request to join a private room
+func HandleRequestToJoin(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	var req models.MembershipRequestNote
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+			return
+		}
+	}
+
+	request, err := manager.RequestToJoin(roomID, userID, req.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+// HandleGetJoinRequests lists the room's pending join requests
+func HandleGetJoinRequests(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermInvite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to answer join requests"})
+		return
+	}
+
+	requests, err := GetJoinRequests(roomID, userID)
+	if err != nil {
+		log.Printf("Error loading join requests of room %s: %v", roomID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load join requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"room_id":  roomID,
+		"requests": requests,
+	})
+}
+
+// HandleDecideJoinRequest approves or denies a user's pending join request
+func HandleDecideJoinRequest(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	var req models.MembershipDecision
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermInvite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to answer join requests"})
+		return
+	}
+
+	if err := manager.DecideJoinRequest(roomID, userID, c.Param("userId"), req.Approve); err == ErrRoomFull {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "room_full"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Join request answered"})
+}
+
+// HandleArchiveRoom archives the room, making it read-only
+func HandleArchiveRoom(c *gin.Context) {
+	handleSetArchived(c, true)
+}
+
+// HandleUnarchiveRoom makes an archived room writable again
+func HandleUnarchiveRoom(c *gin.Context) {
+	handleSetArchived(c, false)
+}
+
+func handleSetArchived(c *gin.Context, archived bool) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermEditRoom) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to edit this room"})
+		return
+	}
+
+	if err := manager.SetArchived(roomID, userID, archived); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"room_id":  roomID,
+		"archived": archived,
+	})
+}
+
+// HandleDeleteRoom deletes the room with its history, only its owner can
+func HandleDeleteRoom(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	room, exists := GetRoom(roomID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if room.Role(userID) != RoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the room's owner can delete it"})
+		return
+	}
+
+	if err := manager.DeleteRoom(roomID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Room deleted"})
+}
+
+// HandleTransferOwnership hands the room to another of its admins
+func HandleTransferOwnership(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	var req models.OwnershipTransfer
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	room, exists := GetRoom(roomID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if room.Role(userID) != RoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the room's owner can transfer it"})
+		return
+	}
+
+	if err := manager.TransferOwnership(roomID, userID, req.UserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ownership transferred"})
+}
+
+// HandleListRooms returns a page of the public room directory, optionally searched by
+// name and description with q and filtered by room_type
+func HandleListRooms(c *gin.Context) {
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	page, err := manager.ListPublicRooms(c.Query("q"), c.Query("room_type"), c.Query("cursor"), limit)
+	if err != nil {
+		log.Printf("Error listing rooms: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list rooms"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// HandleGetRoomLimits returns the room's capacity limits
+func HandleGetRoomLimits(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to view this room"})
+		return
+	}
+
+	limits, err := GetRoomLimits(roomID, userID)
+	if err != nil {
+		log.Printf("Error loading limits of room %s: %v", roomID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load room limits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, limits)
+}
+
+// HandleSetRoomLimits changes the room's capacity limits
+func HandleSetRoomLimits(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	var req models.RoomLimits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermEditRoom) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to edit this room"})
+		return
+	}
+
+	if err := manager.SetRoomLimits(roomID, userID, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+// HandleGetRetention returns how long the room's messages last
+func HandleGetRetention(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	room, exists := GetRoom(roomID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.CanReadRoom(roomID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized for this room"})
+		return
+	}
+
+	room.mutex.RLock()
+	setting := models.RetentionSetting{TTLSeconds: room.MessageTTL}
+	room.mutex.RUnlock()
+
+	c.JSON(http.StatusOK, setting)
+}
+
+// HandleSetRetention makes the room's new messages disappear after the given time, 0 keeps them
+func HandleSetRetention(c *gin.Context) {
+	roomID := c.Param("roomId")
+	userID := c.GetString("userUUID")
+
+	var req models.RetentionSetting
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	if _, exists := GetRoom(roomID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	if !manager.Authorize(roomID, userID, PermEditRoom) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to edit this room"})
+		return
+	}
+
+	if err := manager.SetMessageTTL(roomID, userID, req.TTLSeconds); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+// HandleGetMentions returns the newest entries of the user's mention inbox
+func HandleGetMentions(c *gin.Context) {
+	userID := c.GetString("userUUID")
+
+	limit := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	mentions, err := GetMentions(userID, limit)
+	if err != nil {
+		log.Printf("Error loading mentions of %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load mentions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mentions": mentions})
+}
+
+// HandleClearMentions empties the user's mention inbox, or only the mentions of the message_id query parameter
+func HandleClearMentions(c *gin.Context) {
+	userID := c.GetString("userUUID")
+
+	if err := ClearMentions(userID, c.Query("message_id")); err != nil {
+		log.Printf("Error clearing mentions of %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear mentions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Mentions cleared"})
+}
+
 // HandleGetRoom gets details about a specific room
 // func HandleGetRoom(c *gin.Context) {
 // 	roomID := c.Param("roomId")
@@ -344,4 +1118,38 @@ func RegisterChatRoutes(router *gin.Engine) {
 		// chatGroup.POST("/addusertoroom", HandleAddUsertoRoom)
 		chatGroup.GET("/ws", HandleWebSocket)
 	}
+
+	authGroup := chatGroup.Group("")
+	authGroup.Use(auth.AuthRequired())
+	{
+		authGroup.GET("/rooms", HandleListRooms)
+		authGroup.GET("/rooms/:roomId/messages", HandleGetRoomHistory)
+		authGroup.GET("/rooms/:roomId/messages/:messageId/revisions", HandleGetMessageRevisions)
+		authGroup.GET("/rooms/:roomId/messages/:messageId/thread", HandleGetThreadReplies)
+		authGroup.GET("/rooms/:roomId/read", HandleGetRoomReadState)
+		authGroup.GET("/rooms/:roomId/moderation", HandleGetRoomModeration)
+		authGroup.POST("/rooms/:roomId/moderation", HandleModerateRoom)
+		authGroup.GET("/rooms/:roomId/roles", HandleGetRoomRoles)
+		authGroup.PUT("/rooms/:roomId/roles/:role", HandleDefineRole)
+		authGroup.DELETE("/rooms/:roomId/roles/:role", HandleDeleteRole)
+		authGroup.PUT("/rooms/:roomId/members/:userId/role", HandleAssignRole)
+		authGroup.GET("/rooms/:roomId/invites", HandleListInvites)
+		authGroup.POST("/rooms/:roomId/invites", HandleCreateInvite)
+		authGroup.DELETE("/rooms/:roomId/invites/:token", HandleRevokeInvite)
+		authGroup.POST("/invites/:token/redeem", HandleRedeemInvite)
+		authGroup.GET("/rooms/:roomId/join-requests", HandleGetJoinRequests)
+		authGroup.POST("/rooms/:roomId/join-requests", HandleRequestToJoin)
+		authGroup.POST("/rooms/:roomId/join-requests/:userId", HandleDecideJoinRequest)
+		authGroup.POST("/rooms/:roomId/archive", HandleArchiveRoom)
+		authGroup.POST("/rooms/:roomId/unarchive", HandleUnarchiveRoom)
+		authGroup.POST("/rooms/:roomId/owner", HandleTransferOwnership)
+		authGroup.DELETE("/rooms/:roomId", HandleDeleteRoom)
+		authGroup.GET("/rooms/:roomId/limits", HandleGetRoomLimits)
+		authGroup.PUT("/rooms/:roomId/limits", HandleSetRoomLimits)
+		authGroup.GET("/rooms/:roomId/retention", HandleGetRetention)
+		authGroup.PUT("/rooms/:roomId/retention", HandleSetRetention)
+		authGroup.GET("/presence", HandleGetPresence)
+		authGroup.GET("/mentions", HandleGetMentions)
+		authGroup.DELETE("/mentions", HandleClearMentions)
+	}
 }
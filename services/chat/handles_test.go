@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A room that can't be stored in Valkey isn't kept by this node either
+func TestCreateRoomHandleStoreFails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+		"exists": func([]interface{}) (interface{}, error) { return int64(0), nil },
+		"hset":   func([]interface{}) (interface{}, error) { return nil, errors.New("valkey is down") },
+	})
+
+	previous := manager
+	manager = &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client)}
+	t.Cleanup(func() { manager = previous })
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/rooms",
+		strings.NewReader(`{"roomCode": "lounge", "roominfo": {"name": "Lounge", "creator_id": "alice", "room_type": "group"}}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	CreateRoomHandle(c)
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+	if _, exists := manager.GetRoom("lounge"); exists {
+		t.Error("room kept in memory after storing it failed")
+	}
+	if fake.count(func(args []interface{}) bool { return args[0] == "hset" }) == 0 {
+		t.Error("room never stored")
+	}
+}
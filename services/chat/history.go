@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Number of messages returned when the client does not ask for a limit
+	defaultHistoryLimit = 50
+
+	// Upper bound on the number of messages returned in one page
+	maxHistoryLimit = 100
+)
+
+// CanReadRoom reports whether the user is allowed to read the room's history
+func (cm *ChatManager) CanReadRoom(roomID, userID string) bool {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return false
+	}
+
+	if cm.IsBanned(roomID, userID) {
+		return false
+	}
+
+	// Members read through their role, anyone may read a public room
+	if room.IsAuthorized(userID) {
+		return room.Can(userID, PermRead)
+	}
+	return !room.IsPrivate
+}
+
+// GetRoomHistory returns a page of stored messages for a room the user can read
+func GetRoomHistory(roomID, userID, before string, limit int) (*models.HistoryPage, error) {
+	if !manager.CanReadRoom(roomID, userID) {
+		return nil, fmt.Errorf("not authorized to read room %s", roomID)
+	}
+
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	} else if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	messages, nextCursor, err := db.GetRoomMessages(roomID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := attachReactions(messages); err != nil {
+		return nil, err
+	}
+
+	return &models.HistoryPage{
+		RoomID:     roomID,
+		Messages:   messages,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+// handleHistory answers a "history" request with a page of the room's stored messages
+func (c *Client) handleHistory(msg *models.Message) {
+	var req models.HistoryRequest
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			c.reject(NewMessage(msg.RoomID, "system", "Invalid history request", "error"))
+			return
+		}
+	}
+
+	page, err := GetRoomHistory(msg.RoomID, c.UserID, req.Before, req.Limit)
+	if err != nil {
+		log.Printf("Error loading history of room %s for %s: %v", msg.RoomID, c.UserID, err)
+		c.reject(NewMessage(msg.RoomID, "system", "Unable to load room history", "error"))
+		return
+	}
+
+	payload, err := json.Marshal(page)
+	if err != nil {
+		log.Printf("Error marshaling history page: %v", err)
+		return
+	}
+
+	historyMsg := &models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    msg.RoomID,
+		SenderID:  "system",
+		Type:      "history",
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	}
+	sendToClient(c, historyMsg)
+}
@@ -0,0 +1,184 @@
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+)
+
+const (
+	// Expiry of invites created without one
+	defaultInviteExpiry = 7 * 24 * time.Hour
+
+	// Longest an invite may stay valid
+	maxInviteExpiry = 30 * 24 * time.Hour
+)
+
+// newInviteToken returns a random invite code
+func newInviteToken() (string, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// CreateInvite creates an invite to the room, only members who may invite can create one
+// and an invite giving a role needs a member who could assign it
+func (cm *ChatManager) CreateInvite(roomID, userID string, req models.CreateInviteRequest) (*models.Invite, error) {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return nil, fmt.Errorf("Room does not exist")
+	}
+
+	if err := checkNotDirect(room); err != nil {
+		return nil, err
+	}
+
+	if !room.Can(userID, PermInvite) {
+		return nil, fmt.Errorf("You are not allowed to invite members")
+	}
+
+	if req.MaxUses < 0 || req.ExpiresInSeconds < 0 {
+		return nil, fmt.Errorf("Uses and expiry can't be negative")
+	}
+
+	expiry := defaultInviteExpiry
+	if req.ExpiresInSeconds > 0 {
+		expiry = time.Duration(req.ExpiresInSeconds) * time.Second
+	}
+	if expiry > maxInviteExpiry {
+		return nil, fmt.Errorf("Invites expire within %d days at most", int(maxInviteExpiry.Hours()/24))
+	}
+
+	if req.Role != "" && req.Role != RoleMember {
+		if err := cm.checkCanGrantRole(room, userID, req.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		log.Printf("Error generating invite token: %v", err)
+		return nil, fmt.Errorf("Unable to create invite")
+	}
+
+	now := time.Now()
+	invite := &models.Invite{
+		Token:     token,
+		RoomID:    roomID,
+		CreatedBy: userID,
+		Role:      req.Role,
+		MaxUses:   req.MaxUses,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(expiry).Unix(),
+	}
+
+	if err := db.Valkey.CreateInvite(*invite); err != nil {
+		log.Printf("Error storing invite to room %s: %v", roomID, err)
+		return nil, fmt.Errorf("Unable to create invite")
+	}
+
+	log.Printf("User %s created invite %s to room %s", userID, token, roomID)
+	return invite, nil
+}
+
+// ListInvites returns the room's unexpired invites to a member who may invite
+func ListInvites(roomID, userID string) ([]models.Invite, error) {
+	if !manager.Authorize(roomID, userID, PermInvite) {
+		return nil, fmt.Errorf("not allowed to invite to room %s", roomID)
+	}
+
+	return db.Valkey.GetRoomInvites(roomID)
+}
+
+// RevokeInvite deletes an invite to the room so it can't be redeemed anymore
+func (cm *ChatManager) RevokeInvite(roomID, userID, token string) error {
+	if !cm.Authorize(roomID, userID, PermInvite) {
+		return fmt.Errorf("You are not allowed to manage invites")
+	}
+
+	invite, err := db.Valkey.GetInvite(token)
+	if err != nil || invite.RoomID != roomID {
+		return fmt.Errorf("Invite does not exist")
+	}
+
+	if err := db.Valkey.RevokeInvite(roomID, token); err != nil {
+		log.Printf("Error revoking invite %s to room %s: %v", token, roomID, err)
+		return fmt.Errorf("Unable to revoke invite")
+	}
+
+	log.Printf("User %s revoked invite %s to room %s", userID, token, roomID)
+	return nil
+}
+
+// RedeemInvite makes the user a member of the invite's room, members redeeming it again don't use it up
+func (cm *ChatManager) RedeemInvite(token, userID string) (*models.Invite, error) {
+	invite, err := db.Valkey.GetInvite(token)
+	if err == db.ErrInviteNotFound {
+		return nil, fmt.Errorf("Invite is invalid or expired")
+	} else if err != nil {
+		log.Printf("Error getting invite %s: %v", token, err)
+		return nil, fmt.Errorf("Unable to redeem invite")
+	}
+
+	// The room may have been created on another node
+	cm.syncRoom(invite.RoomID, userID)
+
+	room, exists := cm.GetRoom(invite.RoomID)
+	if !exists {
+		return nil, fmt.Errorf("Room does not exist")
+	}
+
+	if cm.isKeptOut(invite.RoomID, userID) {
+		return nil, fmt.Errorf("You are banned or kicked from this room")
+	}
+
+	if room.IsAuthorized(userID) {
+		return invite, nil
+	}
+
+	// A full room doesn't use the invite up
+	added, err := admitRoomMember(invite.RoomID, userID)
+	if err == ErrRoomFull {
+		return nil, ErrRoomFull
+	} else if err != nil {
+		log.Printf("Failed to add user to authorized members: %v", err)
+		return nil, fmt.Errorf("Unable to redeem invite")
+	}
+
+	if err := db.Valkey.RedeemInvite(invite.RoomID, token); err != nil {
+		// A user who was a member already, through another node, stays one
+		if added {
+			if err := RemoveUserFromRoomAuthMembers(invite.RoomID, userID); err != nil {
+				log.Printf("Error removing %s from room %s after a failed redeem: %v", userID, invite.RoomID, err)
+			}
+		}
+
+		switch err {
+		case db.ErrInviteNotFound:
+			return nil, fmt.Errorf("Invite is invalid or expired")
+		case db.ErrInviteUsedUp:
+			return nil, fmt.Errorf("Invite has been used up")
+		default:
+			log.Printf("Error redeeming invite %s: %v", token, err)
+			return nil, fmt.Errorf("Unable to redeem invite")
+		}
+	}
+	room.Authorize(userID)
+
+	if invite.Role != "" && invite.Role != RoleMember {
+		if err := db.Valkey.SetMemberRole(invite.RoomID, userID, invite.Role, isAdminRole(invite.Role)); err != nil {
+			log.Printf("Error giving role %s of invite %s to %s: %v", invite.Role, token, userID, err)
+		} else {
+			cm.rolesChanged(invite.RoomID, invite.CreatedBy)
+		}
+	}
+
+	log.Printf("User %s joined room %s with invite %s", userID, invite.RoomID, token)
+	return invite, nil
+}
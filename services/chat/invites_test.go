@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"encoding/json"
+	"raychat/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// isScriptOn reports whether the command runs a script whose first key starts with keyPrefix
+func isScriptOn(args []interface{}, keyPrefix string) bool {
+	if len(args) < 4 || args[0] != "evalsha" {
+		return false
+	}
+	key, _ := args[3].(string)
+	return strings.HasPrefix(key, keyPrefix)
+}
+
+// A redeem that fails after admitting the user takes back only the membership it added
+func TestRedeemInviteRollback(t *testing.T) {
+	tests := []struct {
+		name       string
+		admitted   int64 // admit script: 1 added, 2 already a member, 0 full
+		redeemed   int64 // redeem script: uses so far, -1 gone, -2 used up
+		wantErr    string
+		wantRedeem bool
+		wantRemove bool
+	}{
+		{"new member, invite gone", 1, -1, "invalid or expired", true, true},
+		{"new member, invite used up", 1, -2, "used up", true, true},
+		{"member through another node, invite gone", 2, -1, "invalid or expired", true, false},
+		{"member through another node, invite used up", 2, -2, "used up", true, false},
+		{"room full", 0, 1, ErrRoomFull.Error(), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invite := models.Invite{Token: "token", RoomID: "lounge", CreatedBy: "owner", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+			data, err := json.Marshal(invite)
+			if err != nil {
+				t.Fatalf("marshal invite: %v", err)
+			}
+
+			fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+				"hmget":  func([]interface{}) (interface{}, error) { return []interface{}{string(data), "0"}, nil },
+				"zscore": func([]interface{}) (interface{}, error) { return nil, redis.Nil },
+				"srem":   func([]interface{}) (interface{}, error) { return int64(1), nil },
+				"evalsha": func(args []interface{}) (interface{}, error) {
+					if isScriptOn(args, "chat:invite:") {
+						return tt.redeemed, nil
+					}
+					return tt.admitted, nil
+				},
+			})
+
+			room := NewRoom("lounge", "Lounge", "owner", false)
+			cm := &ChatManager{Rooms: map[string]*Room{room.ID: room}, Clients: make(map[string]*Client)}
+
+			if _, err := cm.RedeemInvite(invite.Token, "newcomer"); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+
+			redeems := fake.count(func(args []interface{}) bool { return isScriptOn(args, "chat:invite:") })
+			if (redeems > 0) != tt.wantRedeem {
+				t.Errorf("redeem script ran %d times, want it to run: %v", redeems, tt.wantRedeem)
+			}
+
+			removes := fake.count(func(args []interface{}) bool {
+				return args[0] == "srem" && args[1] == "chat:room:lounge:auth" && args[2] == "newcomer"
+			})
+			if (removes > 0) != tt.wantRemove {
+				t.Errorf("membership removed %d times, want it removed: %v", removes, tt.wantRemove)
+			}
+
+			if room.IsAuthorized("newcomer") {
+				t.Error("newcomer is authorized in the local room after a failed redeem")
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Longest note a user can attach to a join request, in characters
+const maxJoinRequestNote = 500
+
+// RequestToJoin files the user's request to join a private room and notifies the members who may let them in
+func (cm *ChatManager) RequestToJoin(roomID, userID, note string) (*models.MembershipRequest, error) {
+	cm.syncRoom(roomID, userID)
+
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return nil, fmt.Errorf("Room does not exist")
+	}
+
+	if err := checkNotDirect(room); err != nil {
+		return nil, err
+	}
+
+	if !room.IsPrivate {
+		return nil, fmt.Errorf("This room is public, join it directly")
+	}
+
+	if room.IsAuthorized(userID) {
+		return nil, fmt.Errorf("You are already a member of this room")
+	}
+
+	if cm.isKeptOut(roomID, userID) {
+		return nil, fmt.Errorf("You are banned or kicked from this room")
+	}
+
+	if utf8.RuneCountInString(note) > maxJoinRequestNote {
+		return nil, fmt.Errorf("The note can't be longer than %d characters", maxJoinRequestNote)
+	}
+
+	pending, err := db.Valkey.GetJoinRequest(roomID, userID)
+	if err != nil {
+		log.Printf("Error getting join request of %s to room %s: %v", userID, roomID, err)
+		return nil, fmt.Errorf("Unable to request to join")
+	}
+
+	request := &models.MembershipRequest{
+		RoomID:    roomID,
+		UserID:    userID,
+		UserName:  cm.userName(userID),
+		Note:      note,
+		CreatedAt: time.Now().Unix(),
+	}
+	// Asking again only updates the note
+	if pending != nil {
+		request.CreatedAt = pending.CreatedAt
+	}
+
+	if err := db.Valkey.SaveJoinRequest(*request); err != nil {
+		log.Printf("Error storing join request of %s to room %s: %v", userID, roomID, err)
+		return nil, fmt.Errorf("Unable to request to join")
+	}
+
+	if pending == nil {
+		cm.notifyApprovers(room, request)
+	}
+
+	log.Printf("User %s requested to join room %s", userID, roomID)
+	return request, nil
+}
+
+// notifyApprovers sends a join request to the room's members who may invite, wherever they are connected
+func (cm *ChatManager) notifyApprovers(room *Room, request *models.MembershipRequest) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		log.Printf("Error marshaling join request: %v", err)
+		return
+	}
+
+	for _, memberID := range room.Members() {
+		if !room.Can(memberID, PermInvite) {
+			continue
+		}
+
+		cm.SendToUser(&UserMessage{UserID: memberID, Message: &models.Message{
+			ID:         uuid.New().String(),
+			RoomID:     room.ID,
+			SenderID:   request.UserID,
+			ReceiverID: memberID,
+			Content:    request.Note,
+			Type:       "join_request",
+			Timestamp:  request.CreatedAt,
+			Payload:    payload,
+		}})
+	}
+}
+
+// GetJoinRequests returns the room's pending join requests to a member who may invite
+func GetJoinRequests(roomID, userID string) ([]models.MembershipRequest, error) {
+	if !manager.Authorize(roomID, userID, PermInvite) {
+		return nil, fmt.Errorf("not allowed to invite to room %s", roomID)
+	}
+
+	return db.Valkey.GetJoinRequests(roomID)
+}
+
+// DecideJoinRequest approves or denies a pending join request and tells the requester
+func (cm *ChatManager) DecideJoinRequest(roomID, userID, requesterID string, approve bool) error {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return fmt.Errorf("Room does not exist")
+	}
+
+	if err := checkNotDirect(room); err != nil {
+		return err
+	}
+
+	if !room.Can(userID, PermInvite) {
+		return fmt.Errorf("You are not allowed to answer join requests")
+	}
+
+	pending, err := db.Valkey.GetJoinRequest(roomID, requesterID)
+	if err != nil {
+		log.Printf("Error getting join request of %s to room %s: %v", requesterID, roomID, err)
+		return fmt.Errorf("Unable to answer join request")
+	}
+	if pending == nil {
+		return fmt.Errorf("There is no pending join request from this user")
+	}
+
+	// The request of a user who doesn't fit in the room, or was banned or kicked since asking, stays pending
+	if approve {
+		if cm.isKeptOut(roomID, requesterID) {
+			return fmt.Errorf("This user is banned or kicked from this room")
+		}
+		if err := AddUserToRoomAuthMembers(roomID, requesterID); err == ErrRoomFull {
+			return ErrRoomFull
+		} else if err != nil {
+			log.Printf("Failed to add user to authorized members: %v", err)
+			return fmt.Errorf("Unable to answer join request")
+		}
+		room.Authorize(requesterID)
+	}
+
+	if _, err := db.Valkey.DeleteJoinRequest(roomID, requesterID); err != nil {
+		log.Printf("Error deleting join request of %s to room %s: %v", requesterID, roomID, err)
+	}
+
+	decision := "join_denied"
+	content := "Your request to join " + room.Name + " was denied"
+	if approve {
+		decision = "join_approved"
+		content = "Your request to join " + room.Name + " was approved"
+	}
+
+	// A requester who is offline finds the decision in their queue on reconnect
+	cm.sendOrQueue(requesterID, &models.Message{
+		ID:         uuid.New().String(),
+		RoomID:     roomID,
+		SenderID:   userID,
+		ReceiverID: requesterID,
+		Content:    content,
+		Type:       decision,
+		Timestamp:  time.Now().Unix(),
+	})
+
+	log.Printf("User %s: %s of %s in room %s", userID, decision, requesterID, roomID)
+	return nil
+}
+
+// handleJoinRequest processes a "join_request", and an admin's "join_approve" or "join_deny" of the user in TargetID
+func (c *Client) handleJoinRequest(msg *models.Message) {
+	var err error
+	switch msg.Type {
+	case "join_request":
+		_, err = c.Manager.RequestToJoin(msg.RoomID, c.UserID, msg.Content)
+		if err == nil {
+			sendToClient(c, NewMessage(msg.RoomID, "system", "Your request to join was sent to the room's admins", "system"))
+		}
+	case "join_approve", "join_deny":
+		err = c.Manager.DecideJoinRequest(msg.RoomID, c.UserID, msg.TargetID, msg.Type == "join_approve")
+	}
+
+	if err != nil {
+		c.reject(NewMessage(msg.RoomID, "system", err.Error(), "error"))
+	}
+}
@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"encoding/json"
+	db "raychat/database"
+	"raychat/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Approving a join request admits the requester unless they were banned or kicked since
+// asking, the decision waits in the queue of a requester who is offline
+func TestDecideJoinRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		keptOut   string // restriction the requester got since asking
+		wantErr   string
+		wantAdmit bool
+	}{
+		{"approved", "", "", true},
+		{"banned since", db.RestrictionBan, "banned or kicked", false},
+		{"kicked since", db.RestrictionKick, "banned or kicked", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := json.Marshal(models.MembershipRequest{RoomID: "lounge", UserID: "bob", CreatedAt: time.Now().Unix()})
+			if err != nil {
+				t.Fatalf("marshal request: %v", err)
+			}
+
+			ok := func([]interface{}) (interface{}, error) { return int64(1), nil }
+			fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+				"hget": func([]interface{}) (interface{}, error) { return string(request), nil },
+				"zscore": func(args []interface{}) (interface{}, error) {
+					if tt.keptOut != "" && strings.HasSuffix(args[1].(string), ":"+tt.keptOut) {
+						return float64(time.Now().Add(time.Hour).Unix()), nil
+					}
+					return nil, redis.Nil
+				},
+				"evalsha": ok,
+				"hdel":    ok,
+				"zcount":  func([]interface{}) (interface{}, error) { return int64(0), nil },
+				"xadd":    func([]interface{}) (interface{}, error) { return "1-0", nil },
+				"expire":  func([]interface{}) (interface{}, error) { return true, nil },
+			})
+
+			room := NewRoom("lounge", "Lounge", "owner", true)
+			cm := &ChatManager{Rooms: map[string]*Room{room.ID: room}, Clients: make(map[string]*Client)}
+
+			err = cm.DecideJoinRequest(room.ID, "owner", "bob", true)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("DecideJoinRequest: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("DecideJoinRequest = %v, want %q", err, tt.wantErr)
+			}
+
+			if admitted := room.IsAuthorized("bob"); admitted != tt.wantAdmit {
+				t.Errorf("admitted = %v, want %v", admitted, tt.wantAdmit)
+			}
+			if admits := fake.count(func(args []interface{}) bool { return args[0] == "evalsha" }); (admits > 0) != tt.wantAdmit {
+				t.Errorf("admission scripts run %d times, want admitted %v", admits, tt.wantAdmit)
+			}
+
+			queued := fake.count(func(args []interface{}) bool { return args[0] == "xadd" && args[1] == "chat:user:bob:queue" })
+			if wantQueued := tt.wantErr == ""; (queued == 1) != wantQueued {
+				t.Errorf("decision queued %d times, want queued %v", queued, wantQueued)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package chat
+
+import (
+	"encoding/json"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Mentions everyone authorized in the room
+	roomMention = "room"
+
+	// Longest excerpt of the message kept in a mention, in runes
+	maxMentionExcerpt = 200
+
+	defaultMentionLimit = 50
+	maxMentionLimit     = 500
+)
+
+// An @ only starts a mention at the start of the content or after whitespace, so emails don't match
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@([\w.-]+)`)
+
+// parseMentions returns the lowercased names mentioned in the content, without duplicates
+func parseMentions(content string) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// userName returns the name of a user, preferring the one they connected with
+func (cm *ChatManager) userName(userID string) string {
+	client, online := cm.GetClient(userID)
+	if online && client.UserName != "" {
+		return client.UserName
+	}
+
+	name, err := db.Valkey.GetUserName(userID)
+	if err != nil {
+		return ""
+	}
+
+	return name
+}
+
+// userNames returns the names of the users, the ones connected here by the name they
+// connected with and the others fetched from Valkey at once
+func (cm *ChatManager) userNames(userIDs []string) map[string]string {
+	names := make(map[string]string, len(userIDs))
+	missing := make([]string, 0)
+	for _, userID := range userIDs {
+		if client, online := cm.GetClient(userID); online && client.UserName != "" {
+			names[userID] = client.UserName
+		} else {
+			missing = append(missing, userID)
+		}
+	}
+
+	stored, err := db.Valkey.GetUserNames(missing)
+	if err != nil {
+		log.Printf("Error loading user names: %v", err)
+		return names
+	}
+	for userID, name := range stored {
+		names[userID] = name
+	}
+
+	return names
+}
+
+// resolveMentions maps the mentions of a message to the authorized members of its room.
+// The returned map tells for each mentioned user whether they were reached through @room,
+// which only members who may manage the room's messages can use.
+func (cm *ChatManager) resolveMentions(msg *models.Message) map[string]bool {
+	names := parseMentions(msg.Content)
+	if len(names) == 0 {
+		return nil
+	}
+
+	room, exists := cm.GetRoom(msg.RoomID)
+	if !exists {
+		return nil
+	}
+
+	wanted := make(map[string]bool)
+	everyone := false
+	for _, name := range names {
+		if name == roomMention {
+			everyone = room.Can(msg.SenderID, PermManageMessages)
+		} else {
+			wanted[name] = true
+		}
+	}
+	if len(wanted) == 0 && !everyone {
+		return nil
+	}
+
+	members := make([]string, 0)
+	for _, userID := range room.Members() {
+		if userID != msg.SenderID {
+			members = append(members, userID)
+		}
+	}
+
+	var memberNames map[string]string
+	if len(wanted) > 0 {
+		memberNames = cm.userNames(members)
+	}
+
+	mentioned := make(map[string]bool)
+	for _, userID := range members {
+		if wanted[strings.ToLower(memberNames[userID])] {
+			mentioned[userID] = false
+		} else if everyone {
+			mentioned[userID] = true
+		}
+	}
+
+	return mentioned
+}
+
+// notifyMentions stores a mention in the inbox of every member named in the message
+// and sends a live "mention" event to the ones who are connected, off the sender's read loop
+func (cm *ChatManager) notifyMentions(msg *models.Message) {
+	if len(parseMentions(msg.Content)) == 0 {
+		return
+	}
+
+	cm.runInBackground(func() { cm.deliverMentions(msg) })
+}
+
+// deliverMentions fills the inboxes and sends the live events of a message's mentions,
+// a round trip each whatever the number of members mentioned
+func (cm *ChatManager) deliverMentions(msg *models.Message) {
+	mentioned := cm.resolveMentions(msg)
+	if len(mentioned) == 0 {
+		return
+	}
+
+	excerpt := msg.Content
+	if utf8.RuneCountInString(excerpt) > maxMentionExcerpt {
+		excerpt = string([]rune(excerpt)[:maxMentionExcerpt])
+	}
+
+	mentions := make(map[string]models.Mention, len(mentioned))
+	events := make([]*UserMessage, 0, len(mentioned))
+	for userID, everyone := range mentioned {
+		mention := models.Mention{
+			MessageID: msg.ID,
+			RoomID:    msg.RoomID,
+			SenderID:  msg.SenderID,
+			Content:   excerpt,
+			Everyone:  everyone,
+			Timestamp: msg.Timestamp,
+		}
+		mentions[userID] = mention
+
+		payload, err := json.Marshal(mention)
+		if err != nil {
+			log.Printf("Error marshaling mention: %v", err)
+			continue
+		}
+
+		events = append(events, &UserMessage{UserID: userID, Message: &models.Message{
+			ID:         uuid.New().String(),
+			RoomID:     msg.RoomID,
+			SenderID:   msg.SenderID,
+			ReceiverID: userID,
+			TargetID:   msg.ID,
+			Content:    excerpt,
+			Type:       "mention",
+			Timestamp:  time.Now().Unix(),
+			Payload:    payload,
+		}})
+	}
+
+	if err := db.Valkey.AddMentions(mentions); err != nil {
+		log.Printf("Error storing mentions of %s: %v", msg.ID, err)
+	}
+
+	cm.SendToUsers(events)
+}
+
+// GetMentions returns the newest entries of the user's mention inbox
+func GetMentions(userID string, limit int) ([]models.Mention, error) {
+	if limit <= 0 {
+		limit = defaultMentionLimit
+	} else if limit > maxMentionLimit {
+		limit = maxMentionLimit
+	}
+
+	return db.Valkey.GetMentions(userID, limit)
+}
+
+// ClearMentions empties the user's mention inbox, or drops the mentions of a single message
+func ClearMentions(userID, messageID string) error {
+	return db.Valkey.ClearMentions(userID, messageID)
+}
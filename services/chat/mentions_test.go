@@ -0,0 +1,74 @@
+package chat
+
+import (
+	"raychat/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Members named in a message get a mention in their inbox and a live event, the others nothing
+func TestDeliverMentions(t *testing.T) {
+	names := map[interface{}]string{"user:alice": "Alice", "user:bob": "Bob", "user:carol": "Carol", "user:dave": "Dave"}
+	ok := func([]interface{}) (interface{}, error) { return int64(1), nil }
+	fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+		"type":    func([]interface{}) (interface{}, error) { return "hash", nil },
+		"hget":    func(args []interface{}) (interface{}, error) { return names[args[1]], nil },
+		"lpush":   ok,
+		"ltrim":   func([]interface{}) (interface{}, error) { return "OK", nil },
+		"publish": ok,
+	})
+
+	room := NewRoom("lounge", "Lounge", "alice", false)
+	for _, userID := range []string{"bob", "carol", "dave"} {
+		room.AuthorizedMembers[userID] = true
+	}
+	cm := &ChatManager{Rooms: map[string]*Room{room.ID: room}, Clients: make(map[string]*Client), bus: &RoomBus{}}
+
+	cm.deliverMentions(&models.Message{ID: "m1", RoomID: room.ID, SenderID: "alice", Content: "hi @bob and @Carol, @alice", Type: "message"})
+
+	for _, userID := range []string{"alice", "bob", "carol", "dave"} {
+		want := 0
+		if userID == "bob" || userID == "carol" {
+			want = 1
+		}
+
+		stored := fake.count(func(args []interface{}) bool { return args[0] == "lpush" && args[1] == "chat:user:"+userID+":mentions" })
+		if stored != want {
+			t.Errorf("%s: %d mentions stored, want %d", userID, stored, want)
+		}
+		sent := fake.count(func(args []interface{}) bool { return args[0] == "publish" && args[1] == userChannel(userID) })
+		if sent != want {
+			t.Errorf("%s: %d live mentions sent, want %d", userID, sent, want)
+		}
+	}
+}
+
+// Background work runs in the order it was queued, off the caller's goroutine
+func TestRunInBackground(t *testing.T) {
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client)}
+
+	var mutex sync.Mutex
+	order := make([]int, 0)
+	done := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		cm.runInBackground(func() {
+			mutex.Lock()
+			order = append(order, i)
+			mutex.Unlock()
+		})
+	}
+	cm.runInBackground(func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("background work didn't run")
+	}
+
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("ran %d as number %d", got, i)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// loadEditableMessage returns the target of an "edit" or "delete" if the user may make that change
+func (cm *ChatManager) loadEditableMessage(roomID, messageID, userID, change string) (*models.Message, error) {
+	target, err := db.GetMessage(messageID)
+	if err != nil || (roomID != "" && target.RoomID != roomID) {
+		return nil, fmt.Errorf("Message does not exist")
+	}
+
+	if target.Type != "message" && target.Type != "dm" {
+		return nil, fmt.Errorf("Only chat messages can be changed")
+	}
+
+	if target.Deleted {
+		return nil, fmt.Errorf("Message was deleted")
+	}
+
+	if err := cm.checkCanChange(target, userID, change); err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+// checkCanChange lets the sender edit or delete their message, members allowed to
+// manage messages may delete others' messages but never put words in their mouth
+func (cm *ChatManager) checkCanChange(target *models.Message, userID, change string) error {
+	if target.SenderID == userID {
+		return nil
+	}
+
+	if change == "edit" {
+		return fmt.Errorf("Only the sender can edit a message")
+	}
+
+	if !cm.Authorize(target.RoomID, userID, PermManageMessages) {
+		return fmt.Errorf("You are not allowed to delete this message")
+	}
+
+	return nil
+}
+
+// handleMessageChange processes "edit" and "delete" and broadcasts the change so clients update in place
+func (c *Client) handleMessageChange(msg *models.Message) {
+	if msg.TargetID == "" {
+		c.reject(NewMessage(msg.RoomID, "system", "An "+msg.Type+" needs the target_id of a message", "error"))
+		return
+	}
+
+	target, err := c.Manager.loadEditableMessage(msg.RoomID, msg.TargetID, c.UserID, msg.Type)
+	if err != nil {
+		c.reject(NewMessage(msg.RoomID, "system", err.Error(), "error"))
+		return
+	}
+
+	// Muted users can still take their messages down
+	if msg.Type == "edit" && !c.checkCanPost(target.RoomID, PermPost) {
+		return
+	}
+
+	now := time.Now().Unix()
+	change := &models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    target.RoomID,
+		SenderID:  c.UserID,
+		TargetID:  target.ID,
+		Type:      msg.Type,
+		Timestamp: now,
+		EditedAt:  now,
+	}
+
+	switch msg.Type {
+	case "edit":
+		if msg.Content == "" {
+			c.reject(NewMessage(msg.RoomID, "system", "An edit needs the new content", "error"))
+			return
+		}
+
+		if err := db.EditMessage(target.ID, msg.Content, c.UserID, now); err != nil {
+			log.Printf("Error editing message %s: %v", target.ID, err)
+			c.reject(NewMessage(msg.RoomID, "system", "Unable to edit message", "error"))
+			return
+		}
+		change.Content = msg.Content
+
+	case "delete":
+		if err := db.DeleteMessage(target.ID, now); err != nil {
+			log.Printf("Error deleting message %s: %v", target.ID, err)
+			c.reject(NewMessage(msg.RoomID, "system", "Unable to delete message", "error"))
+			return
+		}
+		change.Deleted = true
+	}
+
+	c.Manager.Broadcast(change)
+}
+
+// GetMessageRevisions returns the edit history of a message in a room the user can read
+func GetMessageRevisions(roomID, messageID, userID string) ([]models.MessageRevision, error) {
+	if !manager.CanReadRoom(roomID, userID) {
+		return nil, fmt.Errorf("not authorized to read room %s", roomID)
+	}
+
+	target, err := db.GetMessage(messageID)
+	if err != nil || target.RoomID != roomID {
+		return nil, fmt.Errorf("message %s not found in room %s", messageID, roomID)
+	}
+
+	return db.GetMessageRevisions(messageID)
+}
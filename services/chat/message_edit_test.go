@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"raychat/models"
+	"testing"
+)
+
+// Senders change their own messages, moderators may only delete others'
+func TestCheckCanChange(t *testing.T) {
+	room := rolesRoom()
+	cm := &ChatManager{Rooms: map[string]*Room{room.ID: room}, Clients: make(map[string]*Client)}
+	target := &models.Message{ID: "m1", RoomID: room.ID, SenderID: "member", Type: "message"}
+
+	tests := []struct {
+		userID     string
+		wantEdit   bool
+		wantDelete bool
+	}{
+		{"member", true, true},
+		{"owner", false, true},
+		{"admin", false, true},
+		{"moderator", false, true},
+		{"pinner", false, false},
+		{"reader", false, false},
+		{"stranger", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userID, func(t *testing.T) {
+			if err := cm.checkCanChange(target, tt.userID, "edit"); (err == nil) != tt.wantEdit {
+				t.Errorf("edit: %v, want allowed %v", err, tt.wantEdit)
+			}
+			if err := cm.checkCanChange(target, tt.userID, "delete"); (err == nil) != tt.wantDelete {
+				t.Errorf("delete: %v, want allowed %v", err, tt.wantDelete)
+			}
+		})
+	}
+}
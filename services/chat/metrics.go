@@ -0,0 +1,19 @@
+package chat
+
+import (
+	"log"
+	"net/http"
+)
+
+// ServeMetrics serves the slow-consumer counters as JSON at /metrics on its own listener,
+// keep addr internal, the public router doesn't expose them
+func ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(slowConsumerMetrics.String()))
+	})
+
+	log.Printf("Metrics server running at %s", addr)
+	return http.ListenAndServe(addr, mux)
+}
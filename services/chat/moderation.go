@@ -0,0 +1,238 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Moderation actions, also the types of the messages announcing them
+const (
+	ActionMute   = "mute"
+	ActionUnmute = "unmute"
+	ActionKick   = "kick"
+	ActionBan    = "ban"
+	ActionUnban  = "unban"
+)
+
+// isModeration reports whether the message type is a moderation action
+func isModeration(msgType string) bool {
+	switch msgType {
+	case ActionMute, ActionUnmute, ActionKick, ActionBan, ActionUnban:
+		return true
+	}
+	return false
+}
+
+// IsMuted reports whether the user may read the room but not post in it
+func (cm *ChatManager) IsMuted(roomID, userID string) bool {
+	return isRestricted(roomID, db.RestrictionMute, userID)
+}
+
+// IsBanned reports whether the user is banned from the room
+func (cm *ChatManager) IsBanned(roomID, userID string) bool {
+	return isRestricted(roomID, db.RestrictionBan, userID)
+}
+
+// isKeptOut reports whether a ban or a timed kick keeps the user from joining the room
+func (cm *ChatManager) isKeptOut(roomID, userID string) bool {
+	return cm.IsBanned(roomID, userID) || isRestricted(roomID, db.RestrictionKick, userID)
+}
+
+func isRestricted(roomID, kind, userID string) bool {
+	restricted, err := db.Valkey.IsRoomRestricted(roomID, kind, userID)
+	if err != nil {
+		log.Printf("Error checking %s of %s in room %s: %v", kind, userID, roomID, err)
+		return false
+	}
+	return restricted
+}
+
+// moderationPermission returns the permission a moderation action needs, empty for unknown actions
+func moderationPermission(action string) Permission {
+	switch action {
+	case ActionMute, ActionUnmute:
+		return PermMute
+	case ActionKick:
+		return PermKick
+	case ActionBan, ActionUnban:
+		return PermBan
+	}
+	return ""
+}
+
+// Moderate applies a moderation action of a room moderator and announces it to the room
+func (cm *ChatManager) Moderate(roomID, moderatorID string, req models.ModerationRequest) (*models.Moderation, error) {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return nil, fmt.Errorf("Room does not exist")
+	}
+
+	if err := checkNotDirect(room); err != nil {
+		return nil, err
+	}
+
+	permission := moderationPermission(req.Action)
+	if permission == "" {
+		return nil, fmt.Errorf("Unknown moderation action %q", req.Action)
+	}
+
+	if !room.Can(moderatorID, permission) {
+		return nil, fmt.Errorf("You are not allowed to %s users", req.Action)
+	}
+
+	if req.UserID == "" || req.UserID == moderatorID {
+		return nil, fmt.Errorf("A moderation action needs another user as target")
+	}
+
+	if !room.Outranks(moderatorID, req.UserID) {
+		return nil, fmt.Errorf("You can only moderate members ranked below you")
+	}
+
+	if req.DurationSeconds < 0 {
+		return nil, fmt.Errorf("Duration can't be negative")
+	}
+
+	now := time.Now().Unix()
+	moderation := &models.Moderation{
+		Action:    req.Action,
+		RoomID:    roomID,
+		UserID:    req.UserID,
+		By:        moderatorID,
+		Reason:    req.Reason,
+		CreatedAt: now,
+	}
+	if req.DurationSeconds > 0 {
+		moderation.ExpiresAt = now + req.DurationSeconds
+	}
+
+	var err error
+	switch req.Action {
+	case ActionMute:
+		err = db.Valkey.SetRoomRestriction(db.RestrictionMute, *moderation)
+	case ActionUnmute:
+		err = db.Valkey.ClearRoomRestriction(roomID, db.RestrictionMute, req.UserID)
+	case ActionKick:
+		// A kick without a duration only removes the user from the room, they can join again right away
+		if moderation.ExpiresAt != 0 {
+			err = db.Valkey.SetRoomRestriction(db.RestrictionKick, *moderation)
+		}
+	case ActionBan:
+		if err = db.Valkey.SetRoomRestriction(db.RestrictionBan, *moderation); err == nil {
+			err = db.Valkey.RemoveUserFromRoom(req.UserID, roomID)
+		}
+	case ActionUnban:
+		if err = db.Valkey.ClearRoomRestriction(roomID, db.RestrictionBan, req.UserID); err == nil {
+			err = db.Valkey.ClearRoomRestriction(roomID, db.RestrictionKick, req.UserID)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown moderation action %q", req.Action)
+	}
+	if err != nil {
+		log.Printf("Error applying %s of %s in room %s: %v", req.Action, req.UserID, roomID, err)
+		return nil, fmt.Errorf("Unable to %s user", req.Action)
+	}
+
+	payload, err := json.Marshal(moderation)
+	if err != nil {
+		log.Printf("Error marshaling moderation: %v", err)
+		return moderation, nil
+	}
+
+	// Every node's copy of the room drops kicked and banned users once it delivers the announcement
+	cm.announceChange(room, &models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		SenderID:  moderatorID,
+		TargetID:  req.UserID,
+		Content:   req.Reason,
+		Type:      req.Action,
+		Timestamp: now,
+		Payload:   payload,
+	})
+
+	if req.Action == ActionKick || req.Action == ActionBan {
+		unfollowThreads(roomID, req.UserID)
+	}
+
+	log.Printf("User %s: %s of %s in room %s", moderatorID, req.Action, req.UserID, roomID)
+	return moderation, nil
+}
+
+// GetRoomModeration returns the room's current mutes, timed kicks and bans
+func GetRoomModeration(roomID, userID string) (map[string][]models.Moderation, error) {
+	if !manager.Authorize(roomID, userID, PermMute) {
+		return nil, fmt.Errorf("not a moderator of room %s", roomID)
+	}
+
+	moderation := make(map[string][]models.Moderation)
+	for _, kind := range []string{db.RestrictionMute, db.RestrictionKick, db.RestrictionBan} {
+		restrictions, err := db.Valkey.GetRoomRestrictions(roomID, kind)
+		if err != nil {
+			return nil, err
+		}
+		moderation[kind] = restrictions
+	}
+
+	return moderation, nil
+}
+
+// handleModeration applies a moderation message, the target user is in TargetID
+func (c *Client) handleModeration(msg *models.Message) {
+	var req models.ModerationRequest
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			c.reject(NewMessage(msg.RoomID, "system", "Invalid moderation request", "error"))
+			return
+		}
+	}
+	req.Action = msg.Type
+	req.UserID = msg.TargetID
+	if req.Reason == "" {
+		req.Reason = msg.Content
+	}
+
+	if _, err := c.Manager.Moderate(msg.RoomID, c.UserID, req); err != nil {
+		c.reject(NewMessage(msg.RoomID, "system", err.Error(), "error"))
+	}
+}
+
+// checkCanPost tells a user whose role lacks the permission, or who is muted, that they can't post in the room,
+// it reports whether they can
+func (c *Client) checkCanPost(roomID string, permission Permission) bool {
+	if room, exists := c.Manager.GetRoom(roomID); exists && room.IsArchived() {
+		c.reject(NewMessage(roomID, "system", "This room is archived", "error"))
+		return false
+	}
+
+	if !c.Manager.Authorize(roomID, c.UserID, permission) {
+		content := "Your role doesn't allow this in the room"
+		if permission == PermAnnounce {
+			content = "Only admins and posters can post in this announcement room"
+		}
+		c.reject(NewMessage(roomID, "system", content, "error"))
+		return false
+	}
+
+	if !c.Manager.IsMuted(roomID, c.UserID) {
+		return true
+	}
+
+	c.reject(NewMessage(roomID, "system", "You are muted in this room", "error"))
+	return false
+}
+
+// applyModeration drops a banned user from this node's copy of the room's members,
+// kicked and banned users stop being active once they are told, see settleChange
+func (r *Room) applyModeration(message *models.Message) {
+	if message.Type == ActionBan {
+		r.mutex.Lock()
+		delete(r.AuthorizedMembers, message.TargetID)
+		r.mutex.Unlock()
+	}
+}
@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"errors"
+	"math"
+	db "raychat/database"
+	"raychat/models"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A restriction holds until its expiry, one without an expiry holds until it is lifted
+func TestIsMutedExpiry(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		score func() (interface{}, error)
+		want  bool
+	}{
+		{"not muted", func() (interface{}, error) { return nil, redis.Nil }, false},
+		{"expired", func() (interface{}, error) { return float64(now.Add(-time.Minute).Unix()), nil }, false},
+		{"expiring now", func() (interface{}, error) { return float64(now.Unix()), nil }, false},
+		{"timed", func() (interface{}, error) { return float64(now.Add(time.Minute).Unix()), nil }, true},
+		{"until lifted", func() (interface{}, error) { return math.Inf(1), nil }, true},
+		{"valkey down", func() (interface{}, error) { return nil, errors.New("valkey is down") }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+				"zscore": func([]interface{}) (interface{}, error) { return tt.score() },
+			})
+
+			cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client)}
+			if got := cm.IsMuted("lounge", "bob"); got != tt.want {
+				t.Errorf("IsMuted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A moderation with a duration expires that long after it was made, one without lasts until lifted
+func TestModerateExpiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		action    string
+		duration  int64
+		wantKind  string // restriction stored, empty for none
+		wantTimed bool
+	}{
+		{"timed mute", ActionMute, 60, db.RestrictionMute, true},
+		{"mute until lifted", ActionMute, 0, db.RestrictionMute, false},
+		{"timed kick", ActionKick, 60, db.RestrictionKick, true},
+		{"kick without duration", ActionKick, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok := func([]interface{}) (interface{}, error) { return int64(1), nil }
+			fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+				"zadd":    ok,
+				"hset":    ok,
+				"publish": ok,
+				"srem":    ok,
+			})
+			// Storing the announcement fails, it is still broadcast
+			useUnreachablePostgres(t)
+
+			room := rolesRoom()
+			cm := &ChatManager{Rooms: map[string]*Room{room.ID: room}, Clients: make(map[string]*Client), bus: &RoomBus{}}
+			waitBackground(t, cm)
+
+			before := time.Now().Unix()
+			moderation, err := cm.Moderate(room.ID, "moderator", models.ModerationRequest{Action: tt.action, UserID: "member", DurationSeconds: tt.duration})
+			if err != nil {
+				t.Fatalf("Moderate: %v", err)
+			}
+
+			if tt.wantTimed && (moderation.ExpiresAt < before+tt.duration || moderation.ExpiresAt > time.Now().Unix()+tt.duration) {
+				t.Errorf("ExpiresAt = %d, want %d seconds from now", moderation.ExpiresAt, tt.duration)
+			}
+			if !tt.wantTimed && moderation.ExpiresAt != 0 {
+				t.Errorf("ExpiresAt = %d, want none", moderation.ExpiresAt)
+			}
+
+			scores := make([]interface{}, 0)
+			for _, args := range fake.matching(func(args []interface{}) bool { return args[0] == "zadd" }) {
+				scores = append(scores, args[2])
+			}
+
+			if tt.wantKind == "" {
+				if len(scores) != 0 {
+					t.Errorf("stored %d restrictions, want none", len(scores))
+				}
+				return
+			}
+
+			want := math.Inf(1)
+			if tt.wantTimed {
+				want = float64(moderation.ExpiresAt)
+			}
+			if len(scores) != 1 || scores[0] != want {
+				t.Errorf("restriction scores = %v, want [%v]", scores, want)
+			}
+		})
+	}
+}
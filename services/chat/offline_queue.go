@@ -0,0 +1,141 @@
+package chat
+
+import (
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+)
+
+// Messages read from the offline queue per round trip while flushing
+const queueFlushBatch = 100
+
+// isQueued reports whether members who miss a message of this type get it on reconnect
+// Typing indicators and presence changes are stale by then
+func isQueued(msgType string) bool {
+	return !isEphemeral(msgType) && msgType != "presence"
+}
+
+// storeEvent numbers a room broadcast that isn't a stored message, an edit, a reaction or
+// a change of the room, so clients resuming the room replay it along with the messages
+func (cm *ChatManager) storeEvent(message *models.Message) {
+	// Chat messages are stored with their history, even if storing failed
+	if message.RoomID == "" || message.Seq != 0 || message.Type == "message" || !isQueued(message.Type) {
+		return
+	}
+
+	if err := db.SaveRoomEvent(message); err != nil {
+		log.Printf("Error storing %s event of room %s: %v", message.Type, message.RoomID, err)
+	}
+}
+
+// queueForInactive stores the message for the room's authorized members who are not
+// active in it on any node, so they receive it when they connect again
+func (cm *ChatManager) queueForInactive(message *models.Message) {
+	if !isQueued(message.Type) {
+		return
+	}
+
+	members := cm.RoomMembers(message.RoomID)
+	if len(members) == 0 {
+		return
+	}
+
+	activeUsers, err := db.Valkey.GetActiveUsers(message.RoomID)
+	if err != nil {
+		log.Printf("Error loading active users of room %s, not queueing: %v", message.RoomID, err)
+		return
+	}
+
+	active := make(map[string]bool, len(activeUsers))
+	for _, userID := range activeUsers {
+		active[userID] = true
+	}
+
+	inactive := make([]string, 0)
+	for _, userID := range members {
+		if userID != message.SenderID && !active[userID] {
+			inactive = append(inactive, userID)
+		}
+	}
+
+	if len(inactive) == 0 {
+		return
+	}
+
+	if err := db.Valkey.QueueMessage(inactive, message); err != nil {
+		log.Printf("Error queueing message %s for offline members: %v", message.ID, err)
+	}
+}
+
+// sendOrQueue sends the message to the user's sessions, or stores it in their offline
+// queue when they have no live session on any node
+func (cm *ChatManager) sendOrQueue(userID string, message *models.Message) {
+	connections, err := db.Valkey.CountUserConnections(userID)
+	if err != nil {
+		log.Printf("Error counting connections of %s, sending anyway: %v", userID, err)
+	}
+
+	if err == nil && connections == 0 {
+		if err := db.Valkey.QueueMessage([]string{userID}, message); err != nil {
+			log.Printf("Error queueing message %s for %s: %v", message.ID, userID, err)
+		}
+		return
+	}
+
+	cm.SendToUser(&UserMessage{UserID: userID, Message: message})
+}
+
+// flushQueue sends the client every message queued while its user was away, oldest first.
+// A message is only dropped from the queue once it is on the client's Send channel.
+func (cm *ChatManager) flushQueue(client *Client) {
+	for {
+		queued, err := db.Valkey.GetQueuedMessages(client.UserID, queueFlushBatch)
+		if err != nil {
+			log.Printf("Error reading offline queue of %s: %v", client.UserID, err)
+			return
+		}
+		if len(queued) == 0 {
+			return
+		}
+
+		delivered := make([]string, 0, len(queued))
+		for _, entry := range queued {
+			// Expired messages are dropped with the delivered ones
+			if entry.Message != nil && !isExpired(entry.Message) && !sendWithin(client, entry.Message, writeWait) {
+				break
+			}
+			delivered = append(delivered, entry.StreamID)
+		}
+
+		if err := db.Valkey.AckQueuedMessages(client.UserID, delivered...); err != nil {
+			log.Printf("Error acknowledging offline queue of %s: %v", client.UserID, err)
+			return
+		}
+
+		if len(delivered) < len(queued) {
+			log.Printf("Client %s is not reading, %d queued messages left for later", client.UserID, len(queued)-len(delivered))
+			return
+		}
+	}
+}
+
+// sendWithin queues the message on the client's Send channel, waiting up to timeout for room
+func sendWithin(c *Client, msg *models.Message, timeout time.Duration) bool {
+	c.sendMutex.RLock()
+	defer c.sendMutex.RUnlock()
+
+	if c.closed {
+		return false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case c.Send <- msg:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
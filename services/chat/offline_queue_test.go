@@ -0,0 +1,166 @@
+package chat
+
+import (
+	"encoding/json"
+	"raychat/models"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// queueEntry encodes a message the way QueueMessage stores it
+func queueEntry(t *testing.T, streamID string, msg *models.Message) redis.XMessage {
+	t.Helper()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("marshal message: %v", err)
+	}
+	return redis.XMessage{ID: streamID, Values: map[string]interface{}{"message": string(data)}}
+}
+
+// A flush sends the queued messages in order and acknowledges them, with the expired
+// and undecodable ones dropped
+func TestFlushQueue(t *testing.T) {
+	first := &models.Message{ID: "first", RoomID: "lounge", Type: "message", Content: "hi"}
+	expired := &models.Message{ID: "expired", RoomID: "lounge", Type: "message", ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+	second := &models.Message{ID: "second", RoomID: "lounge", Type: "dm", Content: "hello"}
+
+	batches := [][]redis.XMessage{
+		{
+			queueEntry(t, "1-0", first),
+			queueEntry(t, "2-0", expired),
+			{ID: "3-0", Values: map[string]interface{}{"message": "{broken"}},
+			queueEntry(t, "4-0", second),
+		},
+		{},
+	}
+
+	acked := make([]string, 0)
+	fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+		"xrange": func([]interface{}) (interface{}, error) {
+			batch := batches[0]
+			batches = batches[1:]
+			return batch, nil
+		},
+		"xdel": func(args []interface{}) (interface{}, error) {
+			for _, id := range args[2:] {
+				acked = append(acked, id.(string))
+			}
+			return int64(len(args) - 2), nil
+		},
+	})
+
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client)}
+	client := NewClient("alice", "Alice", nil, cm)
+	cm.flushQueue(client)
+
+	for _, want := range []string{"first", "second"} {
+		select {
+		case msg := <-client.Send:
+			if msg.ID != want {
+				t.Errorf("sent %q, want %q", msg.ID, want)
+			}
+		default:
+			t.Fatalf("%q was not sent", want)
+		}
+	}
+	if len(client.Send) != 0 {
+		t.Errorf("%d extra messages sent", len(client.Send))
+	}
+
+	if want := []string{"1-0", "2-0", "3-0", "4-0"}; len(acked) != len(want) {
+		t.Errorf("acked %v, want %v", acked, want)
+	} else {
+		for i := range want {
+			if acked[i] != want[i] {
+				t.Errorf("acked %v, want %v", acked, want)
+				break
+			}
+		}
+	}
+
+	if reads := fake.count(func(args []interface{}) bool { return args[0] == "xrange" }); reads != 2 {
+		t.Errorf("queue read %d times, want 2", reads)
+	}
+}
+
+// A closed client keeps its queue for the next connection
+func TestFlushQueueClosedClient(t *testing.T) {
+	fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+		"xrange": func([]interface{}) (interface{}, error) {
+			return []redis.XMessage{queueEntry(t, "1-0", &models.Message{ID: "first", Type: "message"})}, nil
+		},
+		"xdel": func(args []interface{}) (interface{}, error) { return int64(len(args) - 2), nil },
+	})
+
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client)}
+	client := NewClient("alice", "Alice", nil, cm)
+	client.closed = true
+	cm.flushQueue(client)
+
+	if acks := fake.count(func(args []interface{}) bool { return args[0] == "xdel" }); acks != 0 {
+		t.Errorf("queue acknowledged %d times, want 0", acks)
+	}
+}
+
+// A direct message to a user with no live session waits in their queue
+func TestSendOrQueueOffline(t *testing.T) {
+	fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+		"zcount": func([]interface{}) (interface{}, error) { return int64(0), nil },
+		"xadd":   func([]interface{}) (interface{}, error) { return "1-0", nil },
+		"expire": func([]interface{}) (interface{}, error) { return true, nil },
+	})
+
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client)}
+	cm.sendOrQueue("bob", &models.Message{ID: "dm", Type: "dm", SenderID: "alice", ReceiverID: "bob"})
+
+	queued := fake.count(func(args []interface{}) bool {
+		return args[0] == "xadd" && args[1] == "chat:user:bob:queue"
+	})
+	if queued != 1 {
+		t.Errorf("queued %d times for bob, want 1", queued)
+	}
+}
+
+// Publishing doesn't wait for the offline queues, they are filled in the background
+func TestPublishQueuesInBackground(t *testing.T) {
+	release := make(chan struct{})
+	fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+		"publish": func([]interface{}) (interface{}, error) { return int64(1), nil },
+		"zrangebyscore": func([]interface{}) (interface{}, error) {
+			<-release
+			return []string{"alice|node-a"}, nil
+		},
+		"xadd":   func([]interface{}) (interface{}, error) { return "1-0", nil },
+		"expire": func([]interface{}) (interface{}, error) { return true, nil },
+	})
+
+	room := NewRoom("lounge", "Lounge", "alice", false)
+	room.AuthorizedMembers["bob"] = true
+	cm := &ChatManager{Rooms: map[string]*Room{room.ID: room}, Clients: make(map[string]*Client), bus: &RoomBus{}}
+	waitBackground(t, cm)
+
+	// A stored message, so nothing reaches Postgres
+	cm.publish(&models.Message{ID: "m1", RoomID: room.ID, SenderID: "carol", Type: "message", Seq: 1})
+	if published := fake.count(func(args []interface{}) bool { return args[0] == "publish" }); published != 1 {
+		t.Fatalf("published %d times, want 1", published)
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for fake.count(func(args []interface{}) bool { return args[0] == "xadd" }) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("message never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	queuedFor := func(userID string) int {
+		return fake.count(func(args []interface{}) bool { return args[0] == "xadd" && args[1] == "chat:user:"+userID+":queue" })
+	}
+	if queuedFor("bob") != 1 || queuedFor("alice") != 0 {
+		t.Errorf("queued for bob %d times and alice %d times, want only bob once", queuedFor("bob"), queuedFor("alice"))
+	}
+}
@@ -0,0 +1,205 @@
+package chat
+
+import (
+	"encoding/json"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+
+	// A connected user is marked away after this long without sending anything,
+	// pongs keep the connection alive but don't count as activity
+	awayAfter = 5 * pongWait
+
+	// How often connected users are checked for idleness
+	presenceCheckPeriod = pingPeriod
+
+	// Upper bound on the number of users in one presence query
+	maxPresenceUsers = 100
+
+	// A connection stops counting this long after its node last refreshed it, so a crashed node's users go offline
+	connectionTTL = 3 * presenceCheckPeriod
+)
+
+// PresenceService tracks the connection state of the users connected to this node
+/*
+Presence is persisted in Valkey so every node can answer presence queries, and
+each change is pushed as a "presence" message to every room the user is a member of.
+A user's connections on every node are counted in Valkey, they go offline once the last one closes.
+*/
+type PresenceService struct {
+	manager *ChatManager
+	users   map[string]*presenceState
+	mutex   sync.Mutex
+}
+
+type presenceState struct {
+	status     string
+	lastActive time.Time
+}
+
+// NewPresenceService creates a presence service for the manager's clients
+func NewPresenceService(manager *ChatManager) *PresenceService {
+	return &PresenceService{
+		manager: manager,
+		users:   make(map[string]*presenceState),
+	}
+}
+
+// Connected counts the client's connection and marks the user online
+func (p *PresenceService) Connected(client *Client) {
+	now := time.Now()
+
+	p.mutex.Lock()
+	p.users[client.UserID] = &presenceState{status: StatusOnline, lastActive: now}
+	p.mutex.Unlock()
+
+	if err := db.Valkey.AddUserConnection(client.UserID, client.ID, connectionTTL); err != nil {
+		log.Printf("Error counting connection of %s: %v", client.UserID, err)
+	}
+	if err := db.Valkey.RecordUserLogin(client.UserID, now); err != nil {
+		log.Printf("Error recording login of %s: %v", client.UserID, err)
+	}
+	p.changed(client.UserID, StatusOnline, now)
+}
+
+// Disconnected stops counting the client's connection, the user is marked offline
+// once no connection is left on any node. Only the first call for a client counts
+func (p *PresenceService) Disconnected(client *Client) {
+	if !client.disconnected.CompareAndSwap(false, true) {
+		return
+	}
+
+	// A newer connection of the user on this node keeps their state
+	if _, connected := p.manager.GetClient(client.UserID); !connected {
+		p.mutex.Lock()
+		delete(p.users, client.UserID)
+		p.mutex.Unlock()
+	}
+
+	left, err := db.Valkey.RemoveUserConnection(client.UserID, client.ID)
+	if err != nil {
+		log.Printf("Error removing connection of %s: %v", client.UserID, err)
+	} else if left > 0 {
+		return
+	}
+
+	p.changed(client.UserID, StatusOffline, time.Now())
+}
+
+// Active records activity from the user, bringing them back online if they were away
+func (p *PresenceService) Active(userID string) {
+	now := time.Now()
+
+	p.mutex.Lock()
+	state, exists := p.users[userID]
+	if !exists {
+		p.mutex.Unlock()
+		return
+	}
+	state.lastActive = now
+	wasAway := state.status == StatusAway
+	state.status = StatusOnline
+	p.mutex.Unlock()
+
+	if wasAway {
+		p.changed(userID, StatusOnline, now)
+	}
+}
+
+// Seen refreshes the user's last-seen time, called whenever a pong arrives
+func (p *PresenceService) Seen(userID string) {
+	if err := db.Valkey.TouchUserLastSeen(userID, time.Now()); err != nil {
+		log.Printf("Error updating last seen of %s: %v", userID, err)
+	}
+}
+
+// Run marks idle users away and keeps this node's connections counted, it blocks forever
+func (p *PresenceService) Run() {
+	ticker := time.NewTicker(presenceCheckPeriod)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		p.refreshConnections()
+
+		idle := make([]string, 0)
+
+		p.mutex.Lock()
+		for userID, state := range p.users {
+			if state.status == StatusOnline && now.Sub(state.lastActive) > awayAfter {
+				state.status = StatusAway
+				idle = append(idle, userID)
+			}
+		}
+		p.mutex.Unlock()
+
+		for _, userID := range idle {
+			p.changed(userID, StatusAway, now)
+		}
+	}
+}
+
+// refreshConnections keeps the connections of this node's clients from lapsing
+func (p *PresenceService) refreshConnections() {
+	p.manager.mutex.RLock()
+	clients := make([]*Client, 0, len(p.manager.Clients))
+	for _, client := range p.manager.Clients {
+		clients = append(clients, client)
+	}
+	p.manager.mutex.RUnlock()
+
+	for _, client := range clients {
+		if err := db.Valkey.RefreshUserConnections(client.UserID, []string{client.ID}, connectionTTL); err != nil {
+			log.Printf("Error refreshing connection of %s: %v", client.UserID, err)
+		}
+	}
+}
+
+// changed persists the new status and pushes it to the user's rooms
+func (p *PresenceService) changed(userID, status string, at time.Time) {
+	if err := db.Valkey.SetUserPresence(userID, status, at); err != nil {
+		log.Printf("Error storing presence of %s: %v", userID, err)
+	}
+
+	payload, err := json.Marshal(models.Presence{
+		UserID:   userID,
+		Status:   status,
+		LastSeen: at.Unix(),
+	})
+	if err != nil {
+		log.Printf("Error marshaling presence: %v", err)
+		return
+	}
+
+	roomIDs, err := db.Valkey.GetUserRooms(userID)
+	if err != nil {
+		log.Printf("Error loading rooms of %s, not pushing presence: %v", userID, err)
+		return
+	}
+
+	for _, roomID := range roomIDs {
+		p.manager.publish(&models.Message{
+			ID:        uuid.New().String(),
+			RoomID:    roomID,
+			SenderID:  userID,
+			Content:   status,
+			Type:      "presence",
+			Timestamp: at.Unix(),
+			Payload:   payload,
+		})
+	}
+}
+
+// GetPresence returns the presence of the given users
+func GetPresence(userIDs []string) ([]models.Presence, error) {
+	return db.Valkey.GetUsersPresence(userIDs)
+}
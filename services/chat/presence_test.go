@@ -0,0 +1,65 @@
+package chat
+
+import "testing"
+
+// usePresenceValkey fakes the commands presence changes send, the user is a member of rooms
+func usePresenceValkey(t *testing.T, rooms ...string) *fakeValkey {
+	t.Helper()
+
+	ok := func([]interface{}) (interface{}, error) { return int64(1), nil }
+	return useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+		"zadd":     ok,
+		"zrem":     ok,
+		"hset":     ok,
+		"publish":  ok,
+		"expire":   func([]interface{}) (interface{}, error) { return true, nil },
+		"smembers": func([]interface{}) (interface{}, error) { return rooms, nil },
+	})
+}
+
+// publishedTo counts the messages published to the room's channel
+func publishedTo(fake *fakeValkey, roomID string) int {
+	return fake.count(func(args []interface{}) bool {
+		return args[0] == "publish" && args[1] == roomChannel(roomID)
+	})
+}
+
+// A user coming online is announced in every room they are a member of, not only the
+// ones they are active in, which are none yet
+func TestConnectedPushesToMemberRooms(t *testing.T) {
+	fake := usePresenceValkey(t, "lounge", "den")
+
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client), bus: &RoomBus{}}
+	cm.presence = NewPresenceService(cm)
+	client := NewClient("alice", "Alice", nil, cm)
+
+	cm.presence.Connected(client)
+
+	for _, roomID := range []string{"lounge", "den"} {
+		if published := publishedTo(fake, roomID); published != 1 {
+			t.Errorf("presence published %d times to %s, want 1", published, roomID)
+		}
+	}
+}
+
+// A client unregistered twice, as the slow-consumer policy can do, stops counting once
+func TestDisconnectedOnce(t *testing.T) {
+	fake := usePresenceValkey(t, "lounge")
+
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client), bus: &RoomBus{}}
+	cm.presence = NewPresenceService(cm)
+	client := NewClient("alice", "Alice", nil, cm)
+
+	cm.presence.Disconnected(client)
+	cm.presence.Disconnected(client)
+
+	removed := fake.count(func(args []interface{}) bool {
+		return args[0] == "zrem" && args[1] == "presence:alice:connections"
+	})
+	if removed != 1 {
+		t.Errorf("connection removed %d times, want 1", removed)
+	}
+	if published := publishedTo(fake, "lounge"); published != 1 {
+		t.Errorf("offline published %d times, want 1", published)
+	}
+}
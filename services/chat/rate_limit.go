@@ -0,0 +1,255 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	db "raychat/database"
+	"raychat/models"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// RateLimit is a token bucket refilled at Rate messages per second, holding up to Burst
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimitConfig holds the limits on inbound messages, read as JSON from RATE_LIMITS
+type RateLimitConfig struct {
+	Connection RateLimit            `json:"connection"` // per WebSocket or gRPC connection
+	User       RateLimit            `json:"user"`       // per user across all their devices and nodes
+	Rooms      map[string]RateLimit `json:"rooms"`      // per room, by RoomType, "default" for the others
+}
+
+// defaultRateLimits apply to whatever RATE_LIMITS leaves out
+var defaultRateLimits = RateLimitConfig{
+	Connection: RateLimit{Rate: 10, Burst: 20},
+	User:       RateLimit{Rate: 15, Burst: 30},
+	Rooms: map[string]RateLimit{
+		"default": {Rate: 30, Burst: 60},
+		"dm":      {Rate: 10, Burst: 20},
+	},
+}
+
+const (
+	defaultRoomLimit = "default"
+
+	// A connection rate limited this many times within offenseWindow is disconnected
+	maxOffenses   = 20
+	offenseWindow = time.Minute
+)
+
+// forRoomType returns the room limit of the room type
+func (c RateLimitConfig) forRoomType(roomType string) RateLimit {
+	if limit, exists := c.Rooms[roomType]; exists {
+		return limit
+	}
+	return c.Rooms[defaultRoomLimit]
+}
+
+// loadRateLimits reads RATE_LIMITS on top of the defaults
+func loadRateLimits() RateLimitConfig {
+	config := RateLimitConfig{
+		Connection: defaultRateLimits.Connection,
+		User:       defaultRateLimits.User,
+		Rooms:      make(map[string]RateLimit),
+	}
+	for roomType, limit := range defaultRateLimits.Rooms {
+		config.Rooms[roomType] = limit
+	}
+
+	raw := os.Getenv("RATE_LIMITS")
+	if raw == "" {
+		return config
+	}
+
+	var configured RateLimitConfig
+	if err := json.Unmarshal([]byte(raw), &configured); err != nil {
+		log.Printf("Invalid RATE_LIMITS, using the defaults: %v", err)
+		return config
+	}
+
+	if configured.Connection.valid() {
+		config.Connection = configured.Connection
+	}
+	if configured.User.valid() {
+		config.User = configured.User
+	}
+	for roomType, limit := range configured.Rooms {
+		if limit.valid() {
+			config.Rooms[roomType] = limit
+		}
+	}
+
+	return config
+}
+
+func (l RateLimit) valid() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// TokenBucket is an in-memory token bucket for limits local to one connection
+type TokenBucket struct {
+	limit     RateLimit
+	tokens    float64
+	updatedAt time.Time
+	mutex     sync.Mutex
+}
+
+// NewTokenBucket creates a full bucket
+func NewTokenBucket(limit RateLimit) *TokenBucket {
+	return &TokenBucket{limit: limit, tokens: float64(limit.Burst), updatedAt: time.Now()}
+}
+
+// Take takes a token, or returns false and how long until one is available
+func (b *TokenBucket) Take(now time.Time) (bool, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+	b.updatedAt = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := (1 - b.tokens) / b.limit.Rate
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// isRateLimited reports whether messages of this type count against the limits.
+// Typing indicators have their own throttle.
+func isRateLimited(msgType string) bool {
+	switch msgType {
+	case "typing_start", "typing_stop":
+		return false
+	}
+	return true
+}
+
+// isConnectionLimitedOnly reports whether messages of this type only count against the connection's limit
+// Receipts follow the room's traffic, so they don't take from the user's or the room's tokens
+func isConnectionLimitedOnly(msgType string) bool {
+	return msgType == "delivered" || msgType == "read"
+}
+
+// isRoomTraffic reports whether messages of this type are broadcast to their room
+func isRoomTraffic(msgType string) bool {
+	switch msgType {
+	case "message", "reaction", "edit", "delete":
+		return true
+	}
+	return false
+}
+
+// allowMessage checks the message against the connection, user and room limits, in that order.
+// A limited client is told when to retry, and disconnected once it keeps ignoring it.
+func (c *Client) allowMessage(msg *models.Message) bool {
+	if !isRateLimited(msg.Type) {
+		return true
+	}
+
+	scope, retryAfter := c.takeTokens(msg)
+	if scope == "" {
+		return true
+	}
+
+	payload, err := json.Marshal(models.RateLimited{Scope: scope, RetryAfterMs: retryAfter.Milliseconds()})
+	if err != nil {
+		log.Printf("Error marshaling rate limit: %v", err)
+	}
+
+	c.reject(&models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    msg.RoomID,
+		SenderID:  "system",
+		TargetID:  msg.ID,
+		Content:   fmt.Sprintf("Too many messages, retry in %s", retryAfter.Round(time.Millisecond)),
+		Type:      "rate_limited",
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	})
+
+	if c.recordOffense() && c.setCloseReason(websocket.ClosePolicyViolation, "rate limit exceeded") {
+		log.Printf("Disconnecting client %s for exceeding rate limits", c.UserID)
+		go c.Manager.Unregister(c)
+	}
+
+	return false
+}
+
+// takeTokens takes a token from each bucket the message counts against, it returns
+// the scope of the first empty bucket and when to retry, or an empty scope
+func (c *Client) takeTokens(msg *models.Message) (string, time.Duration) {
+	limits := c.Manager.rateLimits
+
+	if ok, retryAfter := c.connectionLimiter().Take(time.Now()); !ok {
+		return "connection", retryAfter
+	}
+	if isConnectionLimitedOnly(msg.Type) {
+		return "", 0
+	}
+
+	// Shared buckets live in Valkey, if it can't be reached the message goes through
+	if ok, retryAfter, err := db.Valkey.TakeToken("ratelimit:user:"+c.UserID, limits.User.Rate, limits.User.Burst); err != nil {
+		log.Printf("Error checking rate limit of %s: %v", c.UserID, err)
+	} else if !ok {
+		return "user", retryAfter
+	}
+
+	if msg.RoomID == "" || !isRoomTraffic(msg.Type) {
+		return "", 0
+	}
+
+	room, exists := c.Manager.GetRoom(msg.RoomID)
+	if !exists {
+		return "", 0
+	}
+
+	limit := limits.forRoomType(room.RoomType)
+	if ok, retryAfter, err := db.Valkey.TakeToken("ratelimit:room:"+room.ID, limit.Rate, limit.Burst); err != nil {
+		log.Printf("Error checking rate limit of room %s: %v", room.ID, err)
+	} else if !ok {
+		return "room", retryAfter
+	}
+
+	return "", 0
+}
+
+// connectionLimiter returns the bucket of the client's connection, creating it on first use
+func (c *Client) connectionLimiter() *TokenBucket {
+	c.limitMutex.Lock()
+	defer c.limitMutex.Unlock()
+
+	if c.limiter == nil {
+		c.limiter = NewTokenBucket(c.Manager.rateLimits.Connection)
+	}
+	return c.limiter
+}
+
+// recordOffense counts a rate limited message, it reports whether the client is a repeat offender
+func (c *Client) recordOffense() bool {
+	c.limitMutex.Lock()
+	defer c.limitMutex.Unlock()
+
+	now := time.Now()
+
+	recent := c.offenses[:0]
+	for _, at := range c.offenses {
+		if now.Sub(at) < offenseWindow {
+			recent = append(recent, at)
+		}
+	}
+	c.offenses = append(recent, now)
+
+	return len(c.offenses) >= maxOffenses
+}
@@ -0,0 +1,131 @@
+package chat
+
+import (
+	"raychat/models"
+	"testing"
+	"time"
+)
+
+// A bucket gives out its burst at once, then refills at its rate without going past the burst
+func TestTokenBucket(t *testing.T) {
+	bucket := NewTokenBucket(RateLimit{Rate: 2, Burst: 3})
+	start := bucket.updatedAt
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := bucket.Take(start); !ok {
+			t.Fatalf("take %d of the burst refused", i+1)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		after     time.Duration
+		wantOK    bool
+		wantRetry time.Duration
+	}{
+		{"empty", 0, false, 500 * time.Millisecond},
+		{"partly refilled", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"refilled", 500 * time.Millisecond, true, 0},
+		{"idle for long", time.Hour, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, retryAfter := bucket.Take(start.Add(tt.after))
+			if ok != tt.wantOK || retryAfter != tt.wantRetry {
+				t.Errorf("Take = %v, %v, want %v, %v", ok, retryAfter, tt.wantOK, tt.wantRetry)
+			}
+		})
+	}
+
+	// The hour idle refilled the bucket to its burst, one token of which went above
+	now := start.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _ := bucket.Take(now); !ok {
+			t.Fatalf("take %d after idling refused", i+1)
+		}
+	}
+	if ok, _ := bucket.Take(now); ok {
+		t.Error("bucket held more than its burst")
+	}
+}
+
+// Messages take from the connection, user and room buckets, receipts only from the connection's
+func TestTakeTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		msgType   string
+		emptyKey  string // bucket in Valkey that is out of tokens
+		wantScope string
+		wantTakes int // tokens taken from Valkey
+	}{
+		{"message", "message", "", "", 2},
+		{"message, user limited", "message", "ratelimit:user:", "user", 1},
+		{"message, room limited", "message", "ratelimit:room:", "room", 2},
+		{"join, not room traffic", "join", "ratelimit:room:", "", 1},
+		{"read receipt", "read", "ratelimit:user:", "", 0},
+		{"delivered receipt", "delivered", "ratelimit:room:", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeValkey(t, map[string]func([]interface{}) (interface{}, error){
+				"evalsha": func(args []interface{}) (interface{}, error) {
+					if tt.emptyKey != "" && isScriptOn(args, tt.emptyKey) {
+						return []interface{}{int64(0), int64(1500)}, nil
+					}
+					return []interface{}{int64(1), int64(0)}, nil
+				},
+			})
+
+			room := NewRoom("lounge", "Lounge", "owner", false)
+			cm := &ChatManager{Rooms: map[string]*Room{room.ID: room}, Clients: make(map[string]*Client), rateLimits: loadRateLimits()}
+			client := NewClient("alice", "Alice", nil, cm)
+
+			scope, _ := client.takeTokens(&models.Message{RoomID: room.ID, Type: tt.msgType})
+			if scope != tt.wantScope {
+				t.Errorf("scope = %q, want %q", scope, tt.wantScope)
+			}
+			if takes := fake.count(func(args []interface{}) bool { return args[0] == "evalsha" }); takes != tt.wantTakes {
+				t.Errorf("took %d tokens from Valkey, want %d", takes, tt.wantTakes)
+			}
+		})
+	}
+}
+
+// Receipts still count against the connection, a client flooding them is limited
+func TestTakeTokensConnectionLimit(t *testing.T) {
+	useFakeValkey(t, nil)
+
+	cm := &ChatManager{Rooms: make(map[string]*Room), Clients: make(map[string]*Client), rateLimits: loadRateLimits()}
+	cm.rateLimits.Connection = RateLimit{Rate: 1, Burst: 2}
+	client := NewClient("alice", "Alice", nil, cm)
+
+	for i := 0; i < 2; i++ {
+		if scope, _ := client.takeTokens(&models.Message{RoomID: "lounge", Type: "read"}); scope != "" {
+			t.Fatalf("receipt %d limited by %q", i+1, scope)
+		}
+	}
+	if scope, _ := client.takeTokens(&models.Message{RoomID: "lounge", Type: "read"}); scope != "connection" {
+		t.Errorf("scope = %q, want connection", scope)
+	}
+}
+
+// A client is a repeat offender once it was limited maxOffenses times within the window
+func TestRecordOffense(t *testing.T) {
+	client := NewClient("alice", "Alice", nil, nil)
+
+	// Offenses older than the window are forgotten
+	for i := 0; i < maxOffenses; i++ {
+		client.offenses = append(client.offenses, time.Now().Add(-2*offenseWindow))
+	}
+
+	for i := 1; i < maxOffenses; i++ {
+		if client.recordOffense() {
+			t.Fatalf("repeat offender after %d offenses", i)
+		}
+	}
+	if !client.recordOffense() {
+		t.Errorf("not a repeat offender after %d offenses", maxOffenses)
+	}
+}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Longest emoji accepted in a reaction, in runes, enough for skin tones and ZWJ sequences
+const maxReactionLength = 16
+
+// IsAuthorizedMember reports whether the user is one of the room's authorized members
+func (cm *ChatManager) IsAuthorizedMember(roomID, userID string) bool {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return false
+	}
+
+	return room.IsAuthorized(userID)
+}
+
+// handleReaction toggles the sender's emoji on the target message and broadcasts the new aggregate
+func (c *Client) handleReaction(msg *models.Message) {
+	emoji := msg.Content
+	if msg.TargetID == "" || emoji == "" || utf8.RuneCountInString(emoji) > maxReactionLength {
+		c.reject(NewMessage(msg.RoomID, "system", "A reaction needs the target_id of a message and an emoji", "error"))
+		return
+	}
+
+	target, err := db.GetMessage(msg.TargetID)
+	if err != nil || (msg.RoomID != "" && target.RoomID != msg.RoomID) || target.Deleted {
+		c.reject(NewMessage(msg.RoomID, "system", "Message does not exist", "error"))
+		return
+	}
+
+	if !c.Manager.IsAuthorizedMember(target.RoomID, c.UserID) {
+		c.reject(NewMessage(target.RoomID, "system", "You are not allowed to react in this room", "error"))
+		return
+	}
+
+	if !c.checkCanPost(target.RoomID, PermReact) {
+		return
+	}
+
+	if _, err := db.ToggleReaction(target.ID, c.UserID, emoji); err != nil {
+		log.Printf("Error toggling reaction on %s: %v", target.ID, err)
+		c.reject(NewMessage(target.RoomID, "system", "Unable to react to message", "error"))
+		return
+	}
+
+	summaries, err := db.GetReactionSummaries([]string{target.ID})
+	if err != nil {
+		log.Printf("Error loading reactions of %s: %v", target.ID, err)
+		return
+	}
+
+	c.Manager.Broadcast(&models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    target.RoomID,
+		SenderID:  c.UserID,
+		TargetID:  target.ID,
+		Content:   emoji,
+		Type:      "reaction",
+		Timestamp: time.Now().Unix(),
+		Reactions: summaries[target.ID],
+	})
+}
+
+// attachReactions fills in the reaction summaries of stored messages
+func attachReactions(messages []*models.Message) error {
+	messageIDs := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		messageIDs = append(messageIDs, msg.ID)
+	}
+
+	summaries, err := db.GetReactionSummaries(messageIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		msg.Reactions = summaries[msg.ID]
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// handleReceipt records a "delivered" ack or a "read" cursor for the target message
+// and lets the message's sender know
+func (c *Client) handleReceipt(msg *models.Message) {
+	if msg.TargetID == "" {
+		c.reject(NewMessage(msg.RoomID, "system", "A receipt needs the target_id of a message", "error"))
+		return
+	}
+
+	target, err := db.GetMessage(msg.TargetID)
+	if err != nil || (msg.RoomID != "" && target.RoomID != msg.RoomID) {
+		c.reject(NewMessage(msg.RoomID, "system", "Message does not exist", "error"))
+		return
+	}
+
+	if !c.Manager.CanReadRoom(target.RoomID, c.UserID) {
+		c.reject(NewMessage(target.RoomID, "system", "You are not authorized to read this room", "error"))
+		return
+	}
+
+	now := time.Now().Unix()
+	switch msg.Type {
+	case "delivered":
+		if err := db.Valkey.MarkMessageDelivered(target.RoomID, target.ID, c.UserID); err != nil {
+			log.Printf("Error marking message %s delivered to %s: %v", target.ID, c.UserID, err)
+			return
+		}
+
+	case "read":
+		advanced, err := db.Valkey.SetReadCursor(target.RoomID, models.ReadCursor{
+			UserID:    c.UserID,
+			MessageID: target.ID,
+			Timestamp: target.Timestamp,
+			ReadAt:    now,
+		})
+		if err != nil {
+			log.Printf("Error moving read cursor of %s in room %s: %v", c.UserID, target.RoomID, err)
+			return
+		}
+		if !advanced {
+			return
+		}
+	}
+
+	// Nobody needs a receipt for their own messages
+	if target.SenderID == c.UserID {
+		return
+	}
+
+	payload, err := json.Marshal(models.Receipt{
+		MessageID: target.ID,
+		RoomID:    target.RoomID,
+		UserID:    c.UserID,
+		Status:    msg.Type,
+		Timestamp: now,
+	})
+	if err != nil {
+		log.Printf("Error marshaling receipt: %v", err)
+		return
+	}
+
+	c.Manager.SendToUser(&UserMessage{
+		UserID: target.SenderID,
+		Message: &models.Message{
+			ID:        uuid.New().String(),
+			RoomID:    target.RoomID,
+			SenderID:  c.UserID,
+			TargetID:  target.ID,
+			Type:      "receipt",
+			Timestamp: now,
+			Payload:   payload,
+		},
+	})
+}
+
+// GetRoomReadState returns the read cursor of every member of the room,
+// members who haven't read anything have an empty cursor
+func GetRoomReadState(roomID, userID string) ([]models.ReadCursor, error) {
+	if !manager.CanReadRoom(roomID, userID) {
+		return nil, fmt.Errorf("not authorized to read room %s", roomID)
+	}
+
+	cursors, err := db.Valkey.GetReadCursors(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := make(map[string]models.ReadCursor, len(cursors))
+	for _, cursor := range cursors {
+		byUser[cursor.UserID] = cursor
+	}
+
+	members := manager.RoomMembers(roomID)
+	state := make([]models.ReadCursor, 0, len(members))
+	for _, memberID := range members {
+		cursor, exists := byUser[memberID]
+		if !exists {
+			cursor = models.ReadCursor{UserID: memberID}
+		}
+		state = append(state, cursor)
+	}
+
+	return state, nil
+}
@@ -0,0 +1,135 @@
+package chat
+
+import (
+	"encoding/json"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Most messages replayed to a resuming client per room, older gaps are fetched with "history"
+const maxReplayMessages = 1000
+
+// beginReplay holds back the live messages of the room until endReplay, so
+// messages replayed from storage reach the client before newer ones
+func (c *Client) beginReplay(roomID string) {
+	c.replayMutex.Lock()
+	defer c.replayMutex.Unlock()
+
+	c.replays[roomID] = make([]*models.Message, 0)
+}
+
+// holdForReplay keeps a live message back while its room is being replayed, it reports whether it did
+func (c *Client) holdForReplay(msg *models.Message) bool {
+	c.replayMutex.Lock()
+	defer c.replayMutex.Unlock()
+
+	held, replaying := c.replays[msg.RoomID]
+	if !replaying {
+		return false
+	}
+
+	c.replays[msg.RoomID] = append(held, msg)
+	return true
+}
+
+// endReplay resumes live delivery of the room, sending the messages held back
+// meanwhile unless the replay already covered them
+func (c *Client) endReplay(roomID string, replayedSeq int64) {
+	c.replayMutex.Lock()
+	defer c.replayMutex.Unlock()
+
+	for _, msg := range c.replays[roomID] {
+		if msg.Seq == 0 || msg.Seq > replayedSeq {
+			sendToClient(c, msg)
+		}
+	}
+	delete(c.replays, roomID)
+}
+
+// roomEntriesAfter returns up to limit of the room's stored messages and change events
+// after afterSeq, merged in sequence order
+func roomEntriesAfter(roomID string, afterSeq int64, limit int) ([]*models.Message, error) {
+	messages, err := db.GetRoomMessagesAfter(roomID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := attachReactions(messages); err != nil {
+		log.Printf("Error loading reactions for replay of room %s: %v", roomID, err)
+	}
+
+	events, err := db.GetRoomEventsAfter(roomID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeBySeq(messages, events, limit), nil
+}
+
+// mergeBySeq merges two lists sorted by sequence number into one, keeping up to limit entries
+func mergeBySeq(messages, events []*models.Message, limit int) []*models.Message {
+	entries := make([]*models.Message, 0, len(messages)+len(events))
+	for len(entries) < limit && (len(messages) > 0 || len(events) > 0) {
+		if len(events) == 0 || (len(messages) > 0 && messages[0].Seq < events[0].Seq) {
+			entries, messages = append(entries, messages[0]), messages[1:]
+		} else {
+			entries, events = append(entries, events[0]), events[1:]
+		}
+	}
+
+	return entries
+}
+
+// replayRoom sends the client the room's stored messages and change events after lastSeq, then a "resumed"
+// summary, and resumes live delivery. Messages already flushed from the offline queue
+// may be sent again, clients drop them by sequence number.
+func (c *Client) replayRoom(roomID string, lastSeq int64) {
+	summary := models.ResumeSummary{FromSeq: lastSeq, ToSeq: lastSeq}
+
+	for summary.Replayed < maxReplayMessages {
+		batch, err := roomEntriesAfter(roomID, summary.ToSeq, maxHistoryLimit)
+		if err != nil {
+			log.Printf("Error replaying room %s for %s: %v", roomID, c.UserID, err)
+			break
+		}
+
+		for _, msg := range batch {
+			if !sendWithin(c, msg, writeWait) {
+				log.Printf("Client %s is not reading, stopping replay of room %s", c.UserID, roomID)
+				summary.Truncated = true
+				break
+			}
+			summary.ToSeq = msg.Seq
+			summary.Replayed++
+		}
+
+		if summary.Truncated || len(batch) < maxHistoryLimit {
+			break
+		}
+	}
+
+	if summary.Replayed >= maxReplayMessages {
+		summary.Truncated = true
+	}
+
+	c.endReplay(roomID, summary.ToSeq)
+
+	payload, err := json.Marshal(summary)
+	if err != nil {
+		log.Printf("Error marshaling resume summary: %v", err)
+		return
+	}
+
+	sendToClient(c, &models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		SenderID:  "system",
+		Type:      "resumed",
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	})
+}
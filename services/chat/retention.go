@@ -0,0 +1,198 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// How often expired messages are removed from the history
+	retentionSweepInterval = 15 * time.Second
+
+	// Expired messages removed at a time
+	expiredBatch = 500
+
+	// Bounds of a room's message TTL
+	minMessageTTL = 30 * time.Second
+	maxMessageTTL = 365 * 24 * time.Hour
+
+	// Change events are kept for resuming clients this long, as long as offline queues
+	roomEventTTL = 7 * 24 * time.Hour
+)
+
+// RoomRetention announces a change of the room's message TTL to every node
+const RoomRetention = "retention"
+
+// expiryFor returns when a message of the room sent at sentAt disappears, 0 if it doesn't
+func (r *Room) expiryFor(sentAt int64) int64 {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.MessageTTL == 0 {
+		return 0
+	}
+	return sentAt + r.MessageTTL
+}
+
+// validMessageTTL reports whether a room's messages may last ttlSeconds, 0 keeps them.
+// The bounds are checked in seconds so a huge value can't overflow a time.Duration
+func validMessageTTL(ttlSeconds int64) bool {
+	if ttlSeconds == 0 {
+		return true
+	}
+	return ttlSeconds >= int64(minMessageTTL/time.Second) && ttlSeconds <= int64(maxMessageTTL/time.Second)
+}
+
+func isExpired(msg *models.Message) bool {
+	return msg.ExpiresAt != 0 && msg.ExpiresAt <= time.Now().Unix()
+}
+
+// SetMessageTTL makes the room's messages disappear ttl seconds after they are sent, 0 keeps them.
+// Messages already sent keep the expiry they were stored with
+func (cm *ChatManager) SetMessageTTL(roomID, userID string, ttlSeconds int64) error {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return fmt.Errorf("Room does not exist")
+	}
+
+	if err := checkNotDirect(room); err != nil {
+		return err
+	}
+
+	if !room.Can(userID, PermEditRoom) {
+		return fmt.Errorf("You are not allowed to edit this room")
+	}
+
+	if !validMessageTTL(ttlSeconds) {
+		return fmt.Errorf("Messages must last between %s and %d days", minMessageTTL, int(maxMessageTTL.Hours()/24))
+	}
+	ttl := time.Duration(ttlSeconds) * time.Second
+
+	if err := db.Valkey.SetRoomMessageTTL(roomID, ttlSeconds); err != nil {
+		log.Printf("Error setting message ttl of room %s: %v", roomID, err)
+		return fmt.Errorf("Unable to update room")
+	}
+
+	payload, err := json.Marshal(models.RetentionSetting{TTLSeconds: ttlSeconds})
+	if err != nil {
+		log.Printf("Error marshaling retention: %v", err)
+		return nil
+	}
+
+	content := "Disappearing messages were turned off"
+	if ttlSeconds != 0 {
+		content = fmt.Sprintf("New messages now disappear %s after they are sent", ttl)
+	}
+
+	// The TTL holds here right away, every node picks it up, then the members are told
+	cm.announceChange(room, &models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		SenderID:  userID,
+		Type:      RoomRetention,
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	})
+	cm.Broadcast(NewMessage(roomID, "system", content, "system"))
+
+	log.Printf("User %s set the message ttl of room %s to %ds", userID, roomID, ttlSeconds)
+	return nil
+}
+
+// applyRetention updates this node's copy of the room's message TTL
+func (r *Room) applyRetention(message *models.Message) {
+	var setting models.RetentionSetting
+	if err := json.Unmarshal(message.Payload, &setting); err != nil {
+		log.Printf("Error unmarshaling retention of room %s: %v", r.ID, err)
+		return
+	}
+
+	r.mutex.Lock()
+	r.MessageTTL = setting.TTLSeconds
+	r.mutex.Unlock()
+}
+
+// runRetention removes expired messages from the history and tells the rooms, it never returns
+// Every node sweeps, each expired message is removed and announced by one of them
+func (cm *ChatManager) runRetention() {
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cm.sweepExpired()
+	}
+}
+
+func (cm *ChatManager) sweepExpired() {
+	if err := db.PruneRoomEvents(time.Now().Add(-roomEventTTL).Unix()); err != nil {
+		log.Printf("Error pruning room events: %v", err)
+	}
+
+	for {
+		expired, err := db.DeleteExpiredMessages(time.Now().Unix(), expiredBatch)
+		if err != nil {
+			log.Printf("Error removing expired messages: %v", err)
+			return
+		}
+
+		cm.purgeExpired(expired)
+
+		for _, msg := range expired {
+			cm.Broadcast(&models.Message{
+				ID:        uuid.New().String(),
+				RoomID:    msg.RoomID,
+				SenderID:  "system",
+				TargetID:  msg.ID,
+				Type:      "expired",
+				Timestamp: time.Now().Unix(),
+			})
+		}
+
+		if len(expired) < expiredBatch {
+			return
+		}
+	}
+}
+
+// purgeExpired drops expired messages, and the changes made to them, from the offline
+// queues and mention inboxes of their rooms' members
+func (cm *ChatManager) purgeExpired(expired []*models.Message) {
+	byRoom := make(map[string]map[string]bool)
+	for _, msg := range expired {
+		if byRoom[msg.RoomID] == nil {
+			byRoom[msg.RoomID] = make(map[string]bool)
+		}
+		byRoom[msg.RoomID][msg.ID] = true
+	}
+
+	ids := make([]string, 0, len(expired))
+	for _, msg := range expired {
+		ids = append(ids, msg.ID)
+	}
+	if err := db.DeleteRoomEvents(ids); err != nil {
+		log.Printf("Error removing events of expired messages: %v", err)
+	}
+
+	for roomID, messageIDs := range byRoom {
+		members, err := GetRoomAuthMembers(roomID)
+		if err != nil {
+			log.Printf("Error loading members of room %s to purge expired messages: %v", roomID, err)
+			continue
+		}
+
+		for _, userID := range members {
+			if err := db.Valkey.DropQueuedMessages(userID, messageIDs); err != nil {
+				log.Printf("Error purging expired messages from the queue of %s: %v", userID, err)
+			}
+			if err := db.Valkey.DropMentions(userID, messageIDs); err != nil {
+				log.Printf("Error purging expired mentions of %s: %v", userID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValidMessageTTL(t *testing.T) {
+	tests := []struct {
+		ttlSeconds int64
+		want       bool
+	}{
+		{0, true},
+		{29, false},
+		{30, true},
+		{3600, true},
+		{365 * 24 * 3600, true},
+		{365*24*3600 + 1, false},
+		{-1, false},
+		{-30, false},
+		// Would wrap around as a time.Duration
+		{math.MaxInt64 / 1000, false},
+		{math.MaxInt64, false},
+		{math.MinInt64, false},
+	}
+
+	for _, tt := range tests {
+		if got := validMessageTTL(tt.ttlSeconds); got != tt.want {
+			t.Errorf("validMessageTTL(%d) = %v, want %v", tt.ttlSeconds, got, tt.want)
+		}
+	}
+}
+
+func TestExpiryFor(t *testing.T) {
+	tests := []struct {
+		ttl, sentAt, want int64
+	}{
+		{0, 1700000000, 0},
+		{30, 1700000000, 1700000030},
+		{365 * 24 * 3600, 1700000000, 1700000000 + 365*24*3600},
+	}
+
+	for _, tt := range tests {
+		room := NewRoom("retention", "", "owner", false)
+		room.MessageTTL = tt.ttl
+
+		if got := room.expiryFor(tt.sentAt); got != tt.want {
+			t.Errorf("expiryFor(%d) with ttl %d = %d, want %d", tt.sentAt, tt.ttl, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"regexp"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// Permission is something a member may do in a room
+type Permission string
+
+const (
+	PermRead           Permission = "read"
+	PermPost           Permission = "post"
+	PermAnnounce       Permission = "announce" // post top-level messages in announcement rooms
+	PermReact          Permission = "react"
+	PermInvite         Permission = "invite"
+	PermMute           Permission = "mute"
+	PermKick           Permission = "kick"
+	PermBan            Permission = "ban"
+	PermPin            Permission = "pin"
+	PermManageMessages Permission = "manage_messages" // delete other members' messages
+	PermEditRoom       Permission = "edit_room"
+	PermManageRoles    Permission = "manage_roles"
+
+	// Only the owner deletes the room or hands it over, no role can be given this
+	PermOwnRoom Permission = "own_room"
+)
+
+// allPermissions lists every permission, in the order they are reported
+var allPermissions = []Permission{
+	PermRead, PermPost, PermAnnounce, PermReact, PermInvite, PermMute, PermKick, PermBan,
+	PermPin, PermManageMessages, PermEditRoom, PermManageRoles,
+}
+
+// ownerPermissions are allPermissions and the owner's own
+var ownerPermissions = append(append([]Permission{}, allPermissions...), PermOwnRoom)
+
+// Built-in roles
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+	RoleReadOnly  = "read_only"
+)
+
+// builtinRoles are the permissions of every room's roles until its admins customize them
+var builtinRoles = map[string][]Permission{
+	RoleOwner:     ownerPermissions,
+	RoleAdmin:     allPermissions,
+	RoleModerator: {PermRead, PermPost, PermReact, PermInvite, PermMute, PermKick, PermBan, PermPin, PermManageMessages},
+	RoleMember:    {PermRead, PermPost, PermReact},
+	RoleReadOnly:  {PermRead},
+}
+
+// roleRanks order the built-in roles, nobody acts on a member ranked as high as them, custom roles rank as members
+var roleRanks = map[string]int{
+	RoleOwner:     4,
+	RoleAdmin:     3,
+	RoleModerator: 2,
+	RoleMember:    1,
+	RoleReadOnly:  0,
+}
+
+// RoomRolesChanged is broadcast when the room's roles or their holders change
+const RoomRolesChanged = "roles"
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+func roleRank(role string) int {
+	if rank, exists := roleRanks[role]; exists {
+		return rank
+	}
+	return roleRanks[RoleMember]
+}
+
+// isAdminRole reports whether holders of the role are kept in the room's admins set
+func isAdminRole(role string) bool {
+	return role == RoleOwner || role == RoleAdmin
+}
+
+// roleOf returns the user's role in the room, empty for users who are not members, the caller must hold the room's lock
+func (r *Room) roleOf(userID string) string {
+	// Both users of a direct room are plain members
+	if r.RoomType == RoomTypeDirect {
+		if r.AuthorizedMembers[userID] {
+			return RoleMember
+		}
+		return ""
+	}
+
+	if userID == r.CreatorID {
+		return RoleOwner
+	}
+	if role, exists := r.Roles[userID]; exists && r.AuthorizedMembers[userID] {
+		return role
+	}
+	if r.Admins[userID] {
+		return RoleAdmin
+	}
+	if r.AuthorizedMembers[userID] {
+		return RoleMember
+	}
+	return ""
+}
+
+// permissionsOf returns the permissions of a role, the caller must hold the room's lock
+func (r *Room) permissionsOf(role string) []Permission {
+	if r.RoomType == RoomTypeDirect {
+		if role == "" {
+			return nil
+		}
+		return directPermissions
+	}
+
+	// Built-in roles keep their permissions, overrides stored before they were fixed are ignored
+	if granted, builtin := builtinRoles[role]; builtin {
+		return granted
+	}
+
+	if granted, exists := r.RolePermissions[role]; exists {
+		permissions := make([]Permission, 0, len(granted))
+		for _, permission := range granted {
+			permissions = append(permissions, Permission(permission))
+		}
+		return permissions
+	}
+
+	return nil
+}
+
+// Owner returns the ID of the room's owner, empty for direct rooms
+func (r *Room) Owner() string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.CreatorID
+}
+
+// Role returns the user's role in the room, empty for users who are not members
+func (r *Room) Role(userID string) string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.roleOf(userID)
+}
+
+// Can reports whether the user holds the permission in the room
+func (r *Room) Can(userID string, permission Permission) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	// Nothing is allowed in a deleted room still waiting to be dropped on this node
+	if r.deleted {
+		return false
+	}
+
+	// Archived rooms can be read, unarchived by whoever may edit them, and deleted or handed over by the owner
+	if r.Archived && permission != PermRead && permission != PermEditRoom && permission != PermOwnRoom {
+		return false
+	}
+
+	for _, granted := range r.permissionsOf(r.roleOf(userID)) {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks reports whether the user ranks above the target, so they may act on them
+func (r *Room) Outranks(userID, targetID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return roleRank(r.roleOf(userID)) > roleRank(r.roleOf(targetID))
+}
+
+// Authorize is the single permission check of the chat service
+func (cm *ChatManager) Authorize(roomID, userID string, permission Permission) bool {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return false
+	}
+
+	return room.Can(userID, permission)
+}
+
+// loadRoomRoles reads the room's role assignments and custom roles from Valkey
+func loadRoomRoles(room *Room) {
+	roles, err := db.Valkey.GetRoomRoles(room.ID)
+	if err != nil {
+		log.Printf("Error getting roles for room %s: %v", room.ID, err)
+		roles = make(map[string]string)
+	}
+
+	permissions, err := db.Valkey.GetRolePermissions(room.ID)
+	if err != nil {
+		log.Printf("Error getting role permissions for room %s: %v", room.ID, err)
+		permissions = make(map[string][]string)
+	}
+
+	room.mutex.Lock()
+	room.Roles = roles
+	room.RolePermissions = permissions
+	room.mutex.Unlock()
+}
+
+// GetRoomRoles returns the room's roles and their holders to one of its members
+func GetRoomRoles(roomID, userID string) (*models.RoomRoles, error) {
+	room, exists := GetRoom(roomID)
+	if !exists || !room.Can(userID, PermRead) {
+		return nil, fmt.Errorf("not a member of room %s", roomID)
+	}
+
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+
+	roomRoles := &models.RoomRoles{
+		Roles:   make(map[string][]string),
+		Members: make(map[string]string, len(room.AuthorizedMembers)),
+	}
+
+	for role := range builtinRoles {
+		roomRoles.Roles[role] = permissionNames(room.permissionsOf(role))
+	}
+	for role := range room.RolePermissions {
+		roomRoles.Roles[role] = permissionNames(room.permissionsOf(role))
+	}
+	for memberID := range room.AuthorizedMembers {
+		roomRoles.Members[memberID] = room.roleOf(memberID)
+	}
+
+	return roomRoles, nil
+}
+
+func permissionNames(permissions []Permission) []string {
+	names := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		names = append(names, string(permission))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefineRole creates or changes a custom role, built-in roles can't change
+func (cm *ChatManager) DefineRole(roomID, userID, role string, permissions []string) error {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return fmt.Errorf("Room does not exist")
+	}
+
+	if err := checkCanDefineRole(room, userID, role, permissions); err != nil {
+		return err
+	}
+
+	if err := db.Valkey.SetRolePermissions(roomID, role, permissions); err != nil {
+		log.Printf("Error defining role %s in room %s: %v", role, roomID, err)
+		return fmt.Errorf("Unable to save role")
+	}
+
+	cm.rolesChanged(roomID, userID)
+	return nil
+}
+
+// DeleteRole drops a custom role, its holders are members again
+func (cm *ChatManager) DeleteRole(roomID, userID, role string) error {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return fmt.Errorf("Room does not exist")
+	}
+
+	if err := checkCanManageRole(room, userID, role); err != nil {
+		return err
+	}
+
+	if err := db.Valkey.DeleteRolePermissions(roomID, role); err != nil {
+		log.Printf("Error deleting role %s in room %s: %v", role, roomID, err)
+		return fmt.Errorf("Unable to delete role")
+	}
+
+	cm.rolesChanged(roomID, userID)
+	return nil
+}
+
+// checkCanManageRole checks the user may change or drop the custom role, which must rank below theirs
+func checkCanManageRole(room *Room, userID, role string) error {
+	if err := checkNotDirect(room); err != nil {
+		return err
+	}
+
+	if !room.Can(userID, PermManageRoles) {
+		return fmt.Errorf("You are not allowed to manage roles")
+	}
+
+	if _, builtin := builtinRoles[role]; builtin {
+		return fmt.Errorf("Built-in roles can't be changed")
+	}
+
+	if roleRank(role) >= roleRank(room.Role(userID)) {
+		return fmt.Errorf("You can only manage roles ranked below yours")
+	}
+
+	return nil
+}
+
+// checkCanDefineRole checks the user may give the custom role these permissions, all of which they hold themselves
+func checkCanDefineRole(room *Room, userID, role string, permissions []string) error {
+	if err := checkCanManageRole(room, userID, role); err != nil {
+		return err
+	}
+
+	if !roleNamePattern.MatchString(role) {
+		return fmt.Errorf("Invalid role name %q", role)
+	}
+
+	return checkGrantable(room, userID, permissions)
+}
+
+// checkGrantable checks the permissions exist and the user holds each of them, so a role can't grant more than its maker has
+func checkGrantable(room *Room, userID string, permissions []string) error {
+	known := make(map[Permission]bool, len(allPermissions))
+	for _, permission := range allPermissions {
+		known[permission] = true
+	}
+
+	for _, permission := range permissions {
+		if !known[Permission(permission)] {
+			return fmt.Errorf("Unknown permission %q", permission)
+		}
+		if !room.Can(userID, Permission(permission)) {
+			return fmt.Errorf("You can only grant permissions you hold, not %q", permission)
+		}
+	}
+
+	return nil
+}
+
+// checkCanGrantRole checks the user may give the role to a new member, ownership is never given
+func (cm *ChatManager) checkCanGrantRole(room *Room, userID, role string) error {
+	if err := checkNotDirect(room); err != nil {
+		return err
+	}
+
+	if !room.Can(userID, PermManageRoles) {
+		return fmt.Errorf("You are not allowed to manage roles")
+	}
+
+	if role == RoleOwner {
+		return fmt.Errorf("The owner's role can't be given")
+	}
+
+	room.mutex.RLock()
+	_, builtin := builtinRoles[role]
+	_, custom := room.RolePermissions[role]
+	room.mutex.RUnlock()
+	if !builtin && !custom {
+		return fmt.Errorf("Unknown role %q", role)
+	}
+
+	if !room.Can(userID, PermOwnRoom) && roleRank(role) >= roleRank(room.Role(userID)) {
+		return fmt.Errorf("You can only manage roles ranked below yours")
+	}
+
+	return nil
+}
+
+// AssignRole gives a member of the room a role, ownership can't be handed out this way
+func (cm *ChatManager) AssignRole(roomID, userID, memberID, role string) error {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return fmt.Errorf("Room does not exist")
+	}
+
+	if !room.IsAuthorized(memberID) {
+		return fmt.Errorf("User is not a member of the room")
+	}
+
+	if memberID == room.Owner() {
+		return fmt.Errorf("The owner's role can't be changed")
+	}
+
+	if err := cm.checkCanGrantRole(room, userID, role); err != nil {
+		return err
+	}
+
+	// Members can't be demoted by someone they rank as high as
+	if !room.Can(userID, PermOwnRoom) && !room.Outranks(userID, memberID) {
+		return fmt.Errorf("You can only manage roles ranked below yours")
+	}
+
+	if err := db.Valkey.SetMemberRole(roomID, memberID, role, isAdminRole(role)); err != nil {
+		log.Printf("Error assigning role %s to %s in room %s: %v", role, memberID, roomID, err)
+		return fmt.Errorf("Unable to assign role")
+	}
+
+	cm.rolesChanged(roomID, userID)
+	return nil
+}
+
+// rolesChanged reloads the room's roles here and tells every node to do the same, and the members to refresh theirs
+func (cm *ChatManager) rolesChanged(roomID, userID string) {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return
+	}
+
+	cm.announceChange(room, &models.Message{
+		ID:       uuid.New().String(),
+		RoomID:   roomID,
+		SenderID: userID,
+		Type:     RoomRolesChanged,
+	})
+}
+
+// reloadRoles refreshes this node's copy of the room's roles
+func (r *Room) reloadRoles() {
+	loadRoomRoles(r)
+
+	// Keep the admins map in step with the assigned roles
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for userID, role := range r.Roles {
+		if isAdminRole(role) {
+			r.Admins[userID] = true
+		} else if userID != r.CreatorID {
+			delete(r.Admins, userID)
+		}
+	}
+}
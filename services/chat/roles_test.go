@@ -0,0 +1,203 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+// rolesRoom creates a room holding one member of every built-in role, a holder of a custom role and a stranger
+func rolesRoom() *Room {
+	room := NewRoom("roles", "", "owner", true)
+	for _, userID := range []string{"admin", "moderator", "member", "reader", "pinner"} {
+		room.AuthorizedMembers[userID] = true
+	}
+	room.Admins["admin"] = true
+	room.Roles["moderator"] = RoleModerator
+	room.Roles["reader"] = RoleReadOnly
+	room.Roles["pinner"] = "pinner"
+	room.RolePermissions["pinner"] = []string{string(PermRead), string(PermPin)}
+	return room
+}
+
+func TestRoomCan(t *testing.T) {
+	permissions := []Permission{PermRead, PermPost, PermPin, PermKick, PermManageMessages, PermManageRoles, PermEditRoom, PermOwnRoom}
+
+	tests := []struct {
+		userID string
+		want   []Permission
+	}{
+		{"owner", permissions},
+		{"admin", []Permission{PermRead, PermPost, PermPin, PermKick, PermManageMessages, PermManageRoles, PermEditRoom}},
+		{"moderator", []Permission{PermRead, PermPost, PermPin, PermKick, PermManageMessages}},
+		{"member", []Permission{PermRead, PermPost}},
+		{"reader", []Permission{PermRead}},
+		{"pinner", []Permission{PermRead, PermPin}},
+		{"stranger", nil},
+	}
+
+	for _, archived := range []bool{false, true} {
+		room := rolesRoom()
+		room.Archived = archived
+
+		for _, tt := range tests {
+			granted := make(map[Permission]bool)
+			for _, permission := range tt.want {
+				// Archived rooms can still be read, edited to unarchive them, and owned
+				if !archived || permission == PermRead || permission == PermEditRoom || permission == PermOwnRoom {
+					granted[permission] = true
+				}
+			}
+
+			for _, permission := range permissions {
+				if got := room.Can(tt.userID, permission); got != granted[permission] {
+					t.Errorf("archived=%v: Can(%s, %s) = %v, want %v", archived, tt.userID, permission, got, granted[permission])
+				}
+			}
+		}
+	}
+}
+
+func TestRoomCanNothingOnceDeleted(t *testing.T) {
+	room := rolesRoom()
+	room.deleted = true
+
+	for _, userID := range []string{"owner", "admin", "member"} {
+		for _, permission := range ownerPermissions {
+			if room.Can(userID, permission) {
+				t.Errorf("Can(%s, %s) = true in a deleted room", userID, permission)
+			}
+		}
+	}
+}
+
+func TestRoomOutranks(t *testing.T) {
+	tests := []struct {
+		userID, targetID string
+		want             bool
+	}{
+		{"owner", "admin", true},
+		{"admin", "owner", false},
+		{"admin", "moderator", true},
+		{"moderator", "admin", false},
+		{"moderator", "member", true},
+		{"moderator", "pinner", true},
+		{"member", "pinner", false},
+		{"member", "reader", true},
+		{"member", "member", false},
+	}
+
+	room := rolesRoom()
+	for _, tt := range tests {
+		if got := room.Outranks(tt.userID, tt.targetID); got != tt.want {
+			t.Errorf("Outranks(%s, %s) = %v, want %v", tt.userID, tt.targetID, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCanDefineRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		userID      string
+		role        string
+		permissions []string
+		wantErr     string
+	}{
+		{"admin defines a custom role", "admin", "helper", []string{"read", "pin"}, ""},
+		{"owner changes a custom role", "owner", "pinner", []string{"read", "pin", "invite"}, ""},
+		{"admin redefines admin", "admin", RoleAdmin, []string{"read"}, "Built-in"},
+		{"admin redefines moderator", "admin", RoleModerator, allPermissionNames(), "Built-in"},
+		{"owner redefines member", "owner", RoleMember, []string{"read"}, "Built-in"},
+		{"owner redefines owner", "owner", RoleOwner, []string{"read"}, "Built-in"},
+		{"custom role holder redefines their own role", "roler", "roler", allPermissionNames(), "ranked below"},
+		{"custom role holder defines another custom role", "roler", "helper", []string{"read"}, "ranked below"},
+		{"moderator without manage_roles", "moderator", "helper", []string{"read"}, "not allowed"},
+		{"member", "member", "helper", []string{"read"}, "not allowed"},
+		{"owner-only permission", "owner", "helper", []string{string(PermOwnRoom)}, "Unknown permission"},
+		{"bad name", "admin", "Helper!", []string{"read"}, "Invalid role name"},
+	}
+
+	room := rolesRoom()
+	room.AuthorizedMembers["roler"] = true
+	room.Roles["roler"] = "roler"
+	room.RolePermissions["roler"] = []string{string(PermRead), string(PermInvite), string(PermManageRoles)}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCanDefineRole(room, tt.userID, tt.role, tt.permissions)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("err = %v, want none", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckCanDeleteRole(t *testing.T) {
+	room := rolesRoom()
+	room.AuthorizedMembers["roler"] = true
+	room.Roles["roler"] = "roler"
+	room.RolePermissions["roler"] = []string{string(PermRead), string(PermManageRoles)}
+
+	tests := []struct {
+		userID, role string
+		wantOK       bool
+	}{
+		{"admin", "pinner", true},
+		{"owner", "roler", true},
+		{"admin", RoleModerator, false},
+		{"owner", RoleAdmin, false},
+		{"roler", "roler", false},
+		{"roler", "pinner", false},
+		{"pinner", "pinner", false},
+	}
+
+	for _, tt := range tests {
+		if err := checkCanManageRole(room, tt.userID, tt.role); (err == nil) != tt.wantOK {
+			t.Errorf("checkCanManageRole(%s, %s) = %v, want ok: %v", tt.userID, tt.role, err, tt.wantOK)
+		}
+	}
+}
+
+// Only permissions the maker holds can go into a role
+func TestCheckGrantable(t *testing.T) {
+	tests := []struct {
+		userID      string
+		permissions []string
+		wantOK      bool
+	}{
+		{"member", []string{"read", "post", "react"}, true},
+		{"member", []string{"read", "invite"}, false},
+		{"member", []string{"manage_roles"}, false},
+		{"reader", []string{"post"}, false},
+		{"moderator", []string{"kick", "ban", "pin"}, true},
+		{"moderator", []string{"edit_room"}, false},
+		{"admin", allPermissionNames(), true},
+		{"stranger", []string{"read"}, false},
+	}
+
+	room := rolesRoom()
+	for _, tt := range tests {
+		if err := checkGrantable(room, tt.userID, tt.permissions); (err == nil) != tt.wantOK {
+			t.Errorf("checkGrantable(%s, %v) = %v, want ok: %v", tt.userID, tt.permissions, err, tt.wantOK)
+		}
+	}
+}
+
+// A built-in role keeps its permissions even if an override of it is stored
+func TestBuiltinRoleIgnoresOverride(t *testing.T) {
+	room := rolesRoom()
+	room.RolePermissions[RoleMember] = allPermissionNames()
+
+	if room.Can("member", PermManageRoles) {
+		t.Fatal("a stored override of the member role granted manage_roles")
+	}
+	if !room.Can("member", PermPost) {
+		t.Fatal("member lost post")
+	}
+}
+
+func allPermissionNames() []string {
+	return permissionNames(allPermissions)
+}
@@ -4,19 +4,36 @@ import (
 	"fmt"
 	"log"
 	db "raychat/database"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Room is a chat room, once registered with the ChatManager its members are only
+// changed by the room's own goroutine, see room_actor.go
 type Room struct {
-	ID                string             `json:"id"`
-	Name              string             `json:"name"`
-	CreatorID         string             `json:"creator_id"`
-	AuthorizedMembers map[string]bool    `json:"members"`
-	ActiveMembers     map[string]*Client `json:"active_members"`
-	Admins            map[string]bool    // Users with admin privileges
-	IsPrivate         bool               `json:"is_private"`
+	ID                string              `json:"id"`
+	Name              string              `json:"name"`
+	CreatorID         string              `json:"creator_id"`
+	AuthorizedMembers map[string]bool     `json:"members"`
+	ActiveMembers     map[string]*Client  `json:"active_members"`
+	Admins            map[string]bool     // Users with admin privileges
+	Roles             map[string]string   // Roles assigned to members, the others are owner, admin or member
+	RolePermissions   map[string][]string // Roles customized by the room's admins
+	IsPrivate         bool                `json:"is_private"`
+	RoomType          string              `json:"room_type"`
+	Archived          bool                `json:"archived"`      // Archived rooms are read-only
+	AllowReplies      bool                `json:"allow_replies"` // Members may reply in threads of an announcement room
+	MessageTTL        int64               `json:"message_ttl"`   // Seconds the room's messages last, 0 keeps them
 	CreatedAt         time.Time
+
+	manager *ChatManager
+	mailbox chan func()
+	started sync.Once
+	order   seqOrder     // numbered broadcasts held back until the ones before them arrive
+	deleted bool         // deleted by its owner, the room goes once its members here are told
+	mutex   sync.RWMutex // guards the member maps, readers may be on any goroutine
 }
 
 func LoadAllRoomsWithMembersFromValkey() ([]*Room, error) {
@@ -53,11 +70,15 @@ func LoadAllRoomsWithMembersFromValkey() ([]*Room, error) {
 
 		//Parse the room data
 		room := &Room{
-			ID:        roomID,
-			Name:      roomData["name"],
-			CreatorID: roomData["creator_id"],
-			IsPrivate: roomData["is_private"] == "true",
+			ID:           roomID,
+			Name:         roomData["name"],
+			CreatorID:    roomData["creator_id"],
+			IsPrivate:    roomData["is_private"] == "true",
+			RoomType:     roomData["room_type"],
+			Archived:     roomData["archived"] == "true",
+			AllowReplies: roomData["allow_replies"] == "true",
 		}
+		room.MessageTTL, _ = strconv.ParseInt(roomData["message_ttl"], 10, 64)
 
 		//Initialize auth, maps
 		room.AuthorizedMembers = make(map[string]bool)
@@ -86,9 +107,10 @@ func LoadAllRoomsWithMembersFromValkey() ([]*Room, error) {
 			}
 		}
 
-		// Always ensure creator is both authorized member and admin
-		room.AuthorizedMembers[room.CreatorID] = true
-		room.Admins[room.CreatorID] = true
+		settleOwnership(room)
+
+		loadRoomRoles(room)
+		persistRoom(roomID)
 
 		rooms = append(rooms, room)
 		log.Printf("Loaded room: %s, name: %s, authorized members: %d, admins: %d",
@@ -0,0 +1,354 @@
+package chat
+
+import (
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+)
+
+// Each room runs its own goroutine
+/*
+Membership changes and the fan-out of broadcasts are posted to the room's mailbox and
+run one at a time on the room's goroutine, so a busy room only delays itself. A member
+joining or leaving takes effect between two deliveries, never in the middle of one.
+Reads such as IsAuthorized take the room's read lock and don't wait on the mailbox.
+Broadcasts from the bus never wait on a full mailbox, since one bus goroutine feeds
+every room, see deliver.
+*/
+
+const (
+	// Commands waiting for a room's goroutine before posting to it blocks, and broadcasts are shed
+	roomMailboxSize = 1024
+
+	// A room's active member stops counting this long after their node last refreshed them
+	activeTTL = 3 * activeHeartbeat
+
+	// How often a node refreshes its active members
+	activeHeartbeat = pingPeriod
+)
+
+// post queues the command on the room's goroutine, starting it on first use
+func (r *Room) post(command func()) {
+	r.start()
+	r.mailbox <- command
+}
+
+// tryPost queues the command like post unless the mailbox is full, it reports whether it did
+func (r *Room) tryPost(command func()) bool {
+	r.start()
+
+	select {
+	case r.mailbox <- command:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Room) start() {
+	r.started.Do(func() {
+		r.mailbox = make(chan func(), roomMailboxSize)
+		go r.run()
+	})
+}
+
+// call runs the command on the room's goroutine and waits for it to finish
+func (r *Room) call(command func()) {
+	done := make(chan struct{})
+	r.post(func() {
+		command()
+		close(done)
+	})
+	<-done
+}
+
+func (r *Room) run() {
+	for command := range r.mailbox {
+		command()
+	}
+}
+
+// deliver sends a broadcast to the room's members connected to this node, in sequence order
+// Members who are not active were queued by the node that published the message
+// It doesn't block, a room too far behind drops the broadcast, see shed
+func (r *Room) deliver(message *models.Message) {
+	command := func() {
+		r.deliverInOrder(message)
+	}
+
+	if !r.tryPost(command) {
+		r.shed(message, command)
+	}
+}
+
+// fanOut sends the broadcast to the room's members connected to this node, it runs on the room's goroutine
+func (r *Room) fanOut(message *models.Message) {
+	// A change of the room holds before its members hear of it
+	if changesRoom(message.Type) {
+		r.applyChange(message)
+	}
+
+	r.mutex.RLock()
+	gone := make([]*Client, 0)
+	for userID, client := range r.ActiveMembers {
+		// Ephemeral events like typing indicators are not echoed back to the sender
+		if isEphemeral(message.Type) && userID == message.SenderID {
+			continue
+		}
+
+		// A resuming client gets live messages once the replay of what it missed is over
+		if client.holdForReplay(message) {
+			continue
+		}
+
+		if !sendToClient(client, message) && client.isClosing() {
+			gone = append(gone, client)
+		}
+	}
+	r.mutex.RUnlock()
+
+	// The slow-consumer policy disconnected the client, or it is already gone
+	for _, client := range gone {
+		r.removeActiveMember(client.UserID)
+	}
+
+	// The target of a kick or ban, or the members of a deleted room, were notified above, now they go
+	if changesRoom(message.Type) {
+		r.settleChange(message)
+	}
+}
+
+// shed handles a broadcast the room's full mailbox can't take. A change of the room must
+// still hold, so it waits on its own goroutine, any other broadcast is dropped and recorded
+// as a gap of the room's active members, who resume it once they get the "gap" notice.
+func (r *Room) shed(message *models.Message, command func()) {
+	slowConsumerMetrics.Add("room_backlog", 1)
+
+	if changesRoom(message.Type) {
+		go r.post(command)
+		return
+	}
+
+	log.Printf("Room %s is backed up, dropping %s message %s", r.ID, message.Type, message.ID)
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, client := range r.ActiveMembers {
+		client.recordGap(message)
+	}
+}
+
+// Drop removes the client from the room's active members, unless the user is
+// active through a newer connection
+func (r *Room) Drop(client *Client) {
+	r.call(func() {
+		r.mutex.RLock()
+		current := r.ActiveMembers[client.UserID]
+		r.mutex.RUnlock()
+
+		if current == client {
+			r.removeActiveMember(client.UserID)
+		}
+	})
+}
+
+// IsAuthorized reports whether the user is one of the room's authorized members
+func (r *Room) IsAuthorized(userID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.AuthorizedMembers[userID]
+}
+
+// IsActive reports whether the user is an active member of the room on this node
+func (r *Room) IsActive(userID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	_, isActive := r.ActiveMembers[userID]
+	return isActive
+}
+
+// Members returns the IDs of the room's authorized members
+func (r *Room) Members() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	members := make([]string, 0, len(r.AuthorizedMembers))
+	for userID := range r.AuthorizedMembers {
+		members = append(members, userID)
+	}
+	return members
+}
+
+// ActiveCount returns the number of the room's active members on this node
+func (r *Room) ActiveCount() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.ActiveMembers)
+}
+
+// Join makes the client an active member, members of a public room are authorized on the way
+// It fails with ErrRoomFull once the room's limits are reached
+func (r *Room) Join(client *Client) error {
+	var err error
+	r.call(func() {
+		r.mutex.RLock()
+		authorized := r.AuthorizedMembers[client.UserID]
+		_, active := r.ActiveMembers[client.UserID]
+		deleted := r.deleted
+		r.mutex.RUnlock()
+
+		if deleted {
+			err = errNotAuthorized
+			return
+		}
+
+		if !authorized {
+			if r.IsPrivate {
+				log.Printf("User %s not authorized for private room %s", client.UserID, r.ID)
+				err = errNotAuthorized
+				return
+			}
+
+			if err = admitMember(r.ID, client.UserID); err != nil {
+				return
+			}
+
+			r.mutex.Lock()
+			r.AuthorizedMembers[client.UserID] = true
+			r.mutex.Unlock()
+		}
+
+		if !active {
+			if err = r.manager.admitActive(r.ID, client.UserID); err != nil {
+				return
+			}
+		}
+
+		r.addActiveMember(client)
+	})
+
+	return err
+}
+
+// Activate makes an authorized client an active member, it reports whether the client is active now
+func (r *Room) Activate(client *Client) bool {
+	active := false
+	r.call(func() {
+		if r.IsAuthorized(client.UserID) {
+			r.addActiveMember(client)
+			active = true
+		}
+	})
+
+	return active
+}
+
+// Deactivate removes the user from the room's active members, it reports whether they were active
+func (r *Room) Deactivate(userID string) bool {
+	wasActive := false
+	r.call(func() {
+		wasActive = r.removeActiveMember(userID)
+	})
+
+	return wasActive
+}
+
+// Authorize adds the user to the room's authorized members
+func (r *Room) Authorize(userID string) {
+	r.call(func() {
+		r.mutex.Lock()
+		r.AuthorizedMembers[userID] = true
+		r.mutex.Unlock()
+	})
+}
+
+// Deauthorize removes the user from the room's authorized members, and from its active ones
+func (r *Room) Deauthorize(userID string) {
+	r.call(func() {
+		r.mutex.Lock()
+		delete(r.AuthorizedMembers, userID)
+		r.mutex.Unlock()
+
+		r.removeActiveMember(userID)
+	})
+}
+
+// addActiveMember marks the client active in the room and subscribes this node to
+// the room's broadcasts when it is the first local member, it runs on the room's goroutine
+func (r *Room) addActiveMember(client *Client) {
+	r.mutex.Lock()
+	r.ActiveMembers[client.UserID] = client
+	first := len(r.ActiveMembers) == 1
+	r.mutex.Unlock()
+
+	client.addRoom(r.ID)
+
+	if first {
+		r.order.reset()
+		r.manager.bus.Subscribe(r.ID)
+	}
+
+	if err := db.Valkey.SetUserActive(r.ID, client.UserID, r.manager.nodeID, activeTTL); err != nil {
+		log.Printf("Error marking user %s active in room %s: %v", client.UserID, r.ID, err)
+	}
+}
+
+// removeActiveMember removes the user from the room's active members and unsubscribes
+// this node from the room's broadcasts once no local member is left, it runs on the room's goroutine
+func (r *Room) removeActiveMember(userID string) bool {
+	r.mutex.Lock()
+	client, isActive := r.ActiveMembers[userID]
+	if !isActive {
+		r.mutex.Unlock()
+		return false
+	}
+	delete(r.ActiveMembers, userID)
+	last := len(r.ActiveMembers) == 0
+	r.mutex.Unlock()
+
+	client.removeRoom(r.ID)
+
+	if last {
+		r.manager.bus.Unsubscribe(r.ID)
+		r.order.reset()
+	}
+
+	if err := db.Valkey.SetUserInactive(r.ID, userID, r.manager.nodeID); err != nil {
+		log.Printf("Error marking user %s inactive in room %s: %v", userID, r.ID, err)
+	}
+
+	return true
+}
+
+// runActiveHeartbeat refreshes this node's active members of every room, it never returns
+func (cm *ChatManager) runActiveHeartbeat() {
+	ticker := time.NewTicker(activeHeartbeat)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cm.mutex.RLock()
+		rooms := make([]*Room, 0, len(cm.Rooms))
+		for _, room := range cm.Rooms {
+			rooms = append(rooms, room)
+		}
+		cm.mutex.RUnlock()
+
+		active := make(map[string][]string)
+		for _, room := range rooms {
+			room.mutex.RLock()
+			for userID := range room.ActiveMembers {
+				active[room.ID] = append(active[room.ID], userID)
+			}
+			room.mutex.RUnlock()
+		}
+
+		if err := db.Valkey.RefreshActiveUsers(cm.nodeID, active, activeTTL); err != nil {
+			log.Printf("Error refreshing active members: %v", err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package chat
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"raychat/models"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Members connected to every room of the benchmarks
+const benchMembersPerRoom = 4
+
+func TestMain(m *testing.M) {
+	// The chat service logs every delivery
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// benchRooms creates rooms whose members drain their Send channels and count the deliveries.
+// Send channels hold buffered messages so the readers never count as slow consumers.
+func benchRooms(count, buffered int, delivered *atomic.Int64) ([]*Room, func()) {
+	rooms := make([]*Room, 0, count)
+	clients := make([]*Client, 0, count*benchMembersPerRoom)
+	var drained sync.WaitGroup
+
+	for i := 0; i < count; i++ {
+		room := NewRoom(fmt.Sprintf("bench-%d", i), "", "creator", false)
+		for j := 0; j < benchMembersPerRoom; j++ {
+			client := NewClient(fmt.Sprintf("user-%d-%d", i, j), "", nil, nil)
+			client.Send = make(chan *models.Message, buffered)
+			room.AuthorizedMembers[client.UserID] = true
+			room.ActiveMembers[client.UserID] = client
+			clients = append(clients, client)
+
+			drained.Add(1)
+			go func() {
+				defer drained.Done()
+				for range client.Send {
+					delivered.Add(1)
+				}
+			}()
+		}
+		rooms = append(rooms, room)
+	}
+
+	stop := func() {
+		for _, client := range clients {
+			client.closeSend()
+		}
+		drained.Wait()
+	}
+
+	return rooms, stop
+}
+
+// waitDelivered spins until every client received its copy of the posted messages
+func waitDelivered(delivered *atomic.Int64, want int64) {
+	for delivered.Load() < want {
+		runtime.Gosched()
+	}
+}
+
+// shedCount returns how many broadcasts rooms have shed so far
+func shedCount() int64 {
+	shed, _ := slowConsumerMetrics.Get("room_backlog").(*expvar.Int)
+	if shed == nil {
+		return 0
+	}
+	return shed.Value()
+}
+
+// BenchmarkRoomFanOut measures broadcast throughput with messages spread over many rooms,
+// every room fans out on its own goroutine
+func BenchmarkRoomFanOut(b *testing.B) {
+	for _, roomCount := range []int{1, 100, 1000, 5000} {
+		b.Run(fmt.Sprintf("rooms=%d", roomCount), func(b *testing.B) {
+			var delivered atomic.Int64
+			rooms, stop := benchRooms(roomCount, b.N/roomCount+1, &delivered)
+			defer stop()
+
+			var next atomic.Int64
+			shed := shedCount()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					room := rooms[int(next.Add(1))%len(rooms)]
+					room.deliver(&models.Message{RoomID: room.ID, SenderID: "sender", Type: "message"})
+				}
+			})
+			// Broadcasts a backed-up room shed never arrive
+			waitDelivered(&delivered, (int64(b.N)-(shedCount()-shed))*benchMembersPerRoom)
+			b.StopTimer()
+
+			b.ReportMetric(float64(delivered.Load())/b.Elapsed().Seconds(), "deliveries/s")
+		})
+	}
+}
+
+// BenchmarkQuietRoomsBesideHotRoom measures delivery in quiet rooms while one room is flooded,
+// the hot room's backlog must not hold the others back
+func BenchmarkQuietRoomsBesideHotRoom(b *testing.B) {
+	// Below the mailbox size, so only the quiet rooms could shed
+	const hotBacklog = roomMailboxSize / 2
+
+	var hotDelivered, delivered atomic.Int64
+	hot, stopHot := benchRooms(1, 2*hotBacklog, &hotDelivered)
+	defer stopHot()
+	rooms, stop := benchRooms(1000, b.N/1000+1, &delivered)
+	defer stop()
+
+	flooding := make(chan struct{})
+	var flooder sync.WaitGroup
+	flooder.Add(1)
+	go func() {
+		defer flooder.Done()
+		var posted int64
+		for {
+			select {
+			case <-flooding:
+				return
+			default:
+			}
+
+			// Keep the hot room's readers busy without overflowing them
+			if posted-hotDelivered.Load()/benchMembersPerRoom > hotBacklog {
+				runtime.Gosched()
+				continue
+			}
+			hot[0].deliver(&models.Message{RoomID: hot[0].ID, SenderID: "sender", Type: "message"})
+			posted++
+		}
+	}()
+
+	shed := shedCount()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		room := rooms[i%len(rooms)]
+		room.deliver(&models.Message{RoomID: room.ID, SenderID: "sender", Type: "message"})
+	}
+	waitDelivered(&delivered, (int64(b.N)-(shedCount()-shed))*benchMembersPerRoom)
+	b.StopTimer()
+
+	close(flooding)
+	flooder.Wait()
+
+	b.ReportMetric(float64(delivered.Load())/b.Elapsed().Seconds(), "deliveries/s")
+}
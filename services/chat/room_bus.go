@@ -0,0 +1,188 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RoomBus fans room broadcasts out to every rayChats node through Valkey pub/sub
+/*
+Every broadcast is published on the room's channel, and each node only subscribes
+to the channels of rooms where it has local active members. Messages received from
+a subscription are handed to the chat manager, which delivers them to its own clients.
+Messages for a single user go through the user's channel, which the node holding
+the user's connection is subscribed to. Broadcasts changing a room itself go through
+the control channel every node is subscribed to, so each node's copy of the room
+stays current whether or not it has members in the room.
+*/
+type RoomBus struct {
+	pubsub   *redis.PubSub
+	channels map[string]bool // channels this node is subscribed to
+	mutex    sync.Mutex
+}
+
+// UserMessage is a message addressed to a single user rather than to a room
+type UserMessage struct {
+	UserID  string
+	Message *models.Message
+}
+
+const (
+	roomChannelPrefix = "chat:pubsub:room:"
+	userChannelPrefix = "chat:pubsub:user:"
+	controlChannel    = "chat:pubsub:control"
+)
+
+// roomChannel returns the pub/sub channel carrying a room's broadcasts
+func roomChannel(roomID string) string {
+	return roomChannelPrefix + roomID
+}
+
+// channelFor returns the pub/sub channel a room broadcast is published on
+func channelFor(msg *models.Message) string {
+	if changesRoom(msg.Type) {
+		return controlChannel
+	}
+	return roomChannel(msg.RoomID)
+}
+
+// userChannel returns the pub/sub channel carrying the messages sent to a single user
+func userChannel(userID string) string {
+	return userChannelPrefix + userID
+}
+
+// NewRoomBus creates a bus subscribed to the control channel only
+func NewRoomBus() *RoomBus {
+	return &RoomBus{
+		pubsub:   db.Valkey.Client.Subscribe(db.Valkey.Ctx, controlChannel),
+		channels: map[string]bool{controlChannel: true},
+	}
+}
+
+// Publish sends a message to every node subscribed to its room, or to every node for a change of the room
+func (b *RoomBus) Publish(msg *models.Message) error {
+	return b.publish(channelFor(msg), msg)
+}
+
+// PublishToUser sends a message to the node holding the user's connection
+func (b *RoomBus) PublishToUser(userID string, msg *models.Message) error {
+	return b.publish(userChannel(userID), msg)
+}
+
+// PublishToUsers sends each message to the node holding its user's connection, in one round trip
+func (b *RoomBus) PublishToUsers(messages []*UserMessage) error {
+	pipe := db.Valkey.Client.Pipeline()
+	for _, userMessage := range messages {
+		data, err := json.Marshal(userMessage.Message)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+		pipe.Publish(db.Valkey.Ctx, userChannel(userMessage.UserID), data)
+	}
+
+	if _, err := pipe.Exec(db.Valkey.Ctx); err != nil {
+		return fmt.Errorf("failed to publish messages to users: %w", err)
+	}
+
+	return nil
+}
+
+func (b *RoomBus) publish(channel string, msg *models.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	if err := db.Valkey.Client.Publish(db.Valkey.Ctx, channel, data).Err(); err != nil {
+		return fmt.Errorf("failed to publish message to %s: %w", channel, err)
+	}
+
+	return nil
+}
+
+// Subscribe starts receiving the room's broadcasts on this node
+func (b *RoomBus) Subscribe(roomID string) {
+	b.subscribe(roomChannel(roomID))
+}
+
+// Unsubscribe stops receiving the room's broadcasts on this node
+func (b *RoomBus) Unsubscribe(roomID string) {
+	b.unsubscribe(roomChannel(roomID))
+}
+
+// SubscribeUser starts receiving the messages sent to a user connected to this node
+func (b *RoomBus) SubscribeUser(userID string) {
+	b.subscribe(userChannel(userID))
+}
+
+// UnsubscribeUser stops receiving the messages sent to a user
+func (b *RoomBus) UnsubscribeUser(userID string) {
+	b.unsubscribe(userChannel(userID))
+}
+
+func (b *RoomBus) subscribe(channel string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.channels[channel] {
+		return
+	}
+
+	if err := b.pubsub.Subscribe(db.Valkey.Ctx, channel); err != nil {
+		log.Printf("Error subscribing to %s: %v", channel, err)
+		return
+	}
+	b.channels[channel] = true
+	log.Printf("Subscribed to %s", channel)
+}
+
+func (b *RoomBus) unsubscribe(channel string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.channels[channel] {
+		return
+	}
+
+	if err := b.pubsub.Unsubscribe(db.Valkey.Ctx, channel); err != nil {
+		log.Printf("Error unsubscribing from %s: %v", channel, err)
+		return
+	}
+	delete(b.channels, channel)
+	log.Printf("Unsubscribed from %s", channel)
+}
+
+// Listen hands every message received from the subscriptions to deliver, or to
+// deliverUser for user channels, it blocks until the bus is closed
+// A single goroutine serves every room, so neither may block, rooms shed what they can't take
+func (b *RoomBus) Listen(deliver func(*models.Message), deliverUser func(*UserMessage)) {
+	listen(b.pubsub.Channel(), deliver, deliverUser)
+}
+
+func listen(messages <-chan *redis.Message, deliver func(*models.Message), deliverUser func(*UserMessage)) {
+	for received := range messages {
+		var msg models.Message
+		if err := json.Unmarshal([]byte(received.Payload), &msg); err != nil {
+			log.Printf("Error unmarshaling message from %s: %v", received.Channel, err)
+			continue
+		}
+
+		if userID, isUser := strings.CutPrefix(received.Channel, userChannelPrefix); isUser {
+			deliverUser(&UserMessage{UserID: userID, Message: &msg})
+			continue
+		}
+		deliver(&msg)
+	}
+}
+
+// Close drops all subscriptions
+func (b *RoomBus) Close() error {
+	return b.pubsub.Close()
+}
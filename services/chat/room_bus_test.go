@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"encoding/json"
+	"raychat/models"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// busMessage wraps a room broadcast the way it arrives from a room's channel
+func busMessage(t *testing.T, msg *models.Message) *redis.Message {
+	t.Helper()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("marshal message: %v", err)
+	}
+	return &redis.Message{Channel: roomChannel(msg.RoomID), Payload: string(data)}
+}
+
+// A room whose mailbox is full must not hold up the bus, the other rooms still get their
+// broadcasts and the stuck room's members are told they missed one
+func TestListenSkipsBackedUpRoom(t *testing.T) {
+	var stuckDelivered, delivered atomic.Int64
+	stuckRooms, stopStuck := benchRooms(1, 1, &stuckDelivered)
+	defer stopStuck()
+	quietRooms, stop := benchRooms(1, 1, &delivered)
+	defer stop()
+	stuck, quiet := stuckRooms[0], quietRooms[0]
+	stuck.ID = "stuck"
+
+	release := make(chan struct{})
+	defer close(release)
+	stuck.post(func() { <-release })
+	for i := 0; i < roomMailboxSize; i++ {
+		stuck.post(func() {})
+	}
+
+	rooms := map[string]*Room{stuck.ID: stuck, quiet.ID: quiet}
+	messages := make(chan *redis.Message, 2)
+	messages <- busMessage(t, &models.Message{ID: "m1", RoomID: stuck.ID, SenderID: "sender", Type: "message"})
+	messages <- busMessage(t, &models.Message{ID: "m2", RoomID: quiet.ID, SenderID: "sender", Type: "message"})
+	close(messages)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		listen(messages, func(msg *models.Message) { rooms[msg.RoomID].deliver(msg) }, func(*UserMessage) {})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("listen blocked on a room with a full mailbox")
+	}
+
+	waitDelivered(&delivered, benchMembersPerRoom)
+
+	for _, client := range stuck.ActiveMembers {
+		client.overflowMutex.Lock()
+		gap := client.gap
+		client.overflowMutex.Unlock()
+
+		if gap == nil || gap.Dropped != 1 || len(gap.Rooms) != 1 || gap.Rooms[0] != stuck.ID {
+			t.Fatalf("member %s: gap = %+v, want one message dropped in %s", client.UserID, gap, stuck.ID)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package chat
+
+import "raychat/models"
+
+// Broadcasts changing the room itself reach every node through the control channel
+/*
+Each node applies a change to its copy of the room before fanning the broadcast out
+to its members, and the node making the change applies it to its own copy before
+publishing, so the change holds there right away. Applying a change twice leaves
+the room as applying it once.
+*/
+
+// changesRoom reports whether the broadcast changes the room's state on every node
+func changesRoom(msgType string) bool {
+	return isModeration(msgType) || msgType == RoomRolesChanged || isLifecycle(msgType)
+}
+
+// applyChange updates this node's copy of the room, it may run on any goroutine
+func (r *Room) applyChange(message *models.Message) {
+	switch {
+	case isModeration(message.Type):
+		r.applyModeration(message)
+	case message.Type == RoomRolesChanged:
+		r.reloadRoles()
+	case isLifecycle(message.Type):
+		r.applyLifecycle(message)
+	}
+}
+
+// settleChange drops the members a change leaves out once they were told, it runs on the room's goroutine
+func (r *Room) settleChange(message *models.Message) {
+	switch message.Type {
+	case ActionKick, ActionBan:
+		r.removeActiveMember(message.TargetID)
+
+	case RoomDeleted:
+		r.mutex.RLock()
+		active := make([]string, 0, len(r.ActiveMembers))
+		for userID := range r.ActiveMembers {
+			active = append(active, userID)
+		}
+		r.mutex.RUnlock()
+
+		for _, userID := range active {
+			r.removeActiveMember(userID)
+		}
+		r.manager.removeRoom(r)
+	}
+}
+
+// announceChange applies a change to the local room, then tells every node and the room's members
+func (cm *ChatManager) announceChange(room *Room, message *models.Message) {
+	room.applyChange(message)
+	cm.Broadcast(message)
+}
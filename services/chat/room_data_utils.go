@@ -5,6 +5,7 @@ import (
 	"log"
 	db "raychat/database"
 	"raychat/models"
+	"strconv"
 	"time"
 )
 
@@ -16,12 +17,15 @@ func StoreRoomInValkey(roomID string, roomInfo models.RoomInfo) error {
 
 	// Store main room data as hash
 	roomHash := map[string]interface{}{
-		"name":        roomInfo.Name,
-		"creator_id":  roomInfo.CreatorID,
-		"is_private":  roomInfo.IsPrivate,
-		"room_type":   roomInfo.RoomType,
-		"description": roomInfo.Description,
-		"created_at":  roomInfo.Timestamp.Format(time.RFC3339),
+		"name":          roomInfo.Name,
+		"creator_id":    roomInfo.CreatorID,
+		"is_private":    roomInfo.IsPrivate,
+		"room_type":     roomInfo.RoomType,
+		"description":   roomInfo.Description,
+		"count_limit":   roomInfo.CountLimit,
+		"active_limit":  roomInfo.ActiveLimit,
+		"allow_replies": roomInfo.AllowReplies,
+		"created_at":    roomInfo.Timestamp.Format(time.RFC3339),
 	}
 
 	err := db.Valkey.Client.HSet(db.Valkey.Ctx, roomKey, roomHash).Err()
@@ -29,10 +33,12 @@ func StoreRoomInValkey(roomID string, roomInfo models.RoomInfo) error {
 		return fmt.Errorf("failed to store room data: %w", err)
 	}
 
-	// Store authorized members as set
-	err = db.Valkey.Client.SAdd(db.Valkey.Ctx, authKey, roomInfo.CreatorID).Err()
-	if err != nil {
-		return fmt.Errorf("failed to add creator to auth members: %w", err)
+	// Store authorized members as set, direct rooms have no creator
+	if roomInfo.CreatorID != "" {
+		err = db.Valkey.Client.SAdd(db.Valkey.Ctx, authKey, roomInfo.CreatorID).Err()
+		if err != nil {
+			return fmt.Errorf("failed to add creator to auth members: %w", err)
+		}
 	}
 
 	// Add participants as authorized members if private
@@ -47,15 +53,14 @@ func StoreRoomInValkey(roomID string, roomInfo models.RoomInfo) error {
 	}
 
 	// Store admins as set
-	err = db.Valkey.Client.SAdd(db.Valkey.Ctx, adminsKey, roomInfo.CreatorID).Err()
-	if err != nil {
-		return fmt.Errorf("failed to add creator to admins: %w", err)
+	if roomInfo.CreatorID != "" {
+		err = db.Valkey.Client.SAdd(db.Valkey.Ctx, adminsKey, roomInfo.CreatorID).Err()
+		if err != nil {
+			return fmt.Errorf("failed to add creator to admins: %w", err)
+		}
 	}
 
-	// Set expiration on all keys (24 hours)
-	db.Valkey.Client.Expire(db.Valkey.Ctx, roomKey, 24*time.Hour)
-	db.Valkey.Client.Expire(db.Valkey.Ctx, authKey, 24*time.Hour)
-	db.Valkey.Client.Expire(db.Valkey.Ctx, adminsKey, 24*time.Hour)
+	indexRoom(roomID, roomInfo.Name, roomInfo.RoomType, roomInfo.IsPrivate)
 
 	return nil
 }
@@ -93,8 +98,12 @@ func LoadRoomFromValkey(roomID string) (*Room, error) {
 		ActiveMembers:     make(map[string]*Client),
 		Admins:            make(map[string]bool),
 		IsPrivate:         isPrivate,
+		RoomType:          roomData["room_type"],
+		Archived:          roomData["archived"] == "true",
+		AllowReplies:      roomData["allow_replies"] == "true",
 		CreatedAt:         createdAt,
 	}
+	room.MessageTTL, _ = strconv.ParseInt(roomData["message_ttl"], 10, 64)
 
 	// Get authorized members
 	authMembers, err := db.Valkey.Client.SMembers(db.Valkey.Ctx, authKey).Result()
@@ -116,26 +125,46 @@ func LoadRoomFromValkey(roomID string) (*Room, error) {
 		}
 	}
 
-	// Always ensure creator is both authorized member and admin
-	room.AuthorizedMembers[room.CreatorID] = true
-	room.Admins[room.CreatorID] = true
+	settleOwnership(room)
+
+	loadRoomRoles(room)
+	persistRoom(roomID)
 
 	return room, nil
 }
 
+// settleOwnership makes the creator of a loaded room its admin and member,
+// direct rooms have no owner or admins whatever older entries stored
+func settleOwnership(room *Room) {
+	if room.RoomType == RoomTypeDirect {
+		room.CreatorID = ""
+		room.Admins = make(map[string]bool)
+		return
+	}
+
+	room.AuthorizedMembers[room.CreatorID] = true
+	room.Admins[room.CreatorID] = true
+}
+
+// AddUserToRoomAuthMembers authorizes the user for the room, it fails with ErrRoomFull
+// once the room has reached its count_limit
 func AddUserToRoomAuthMembers(roomID, userID string) error {
-	authKey := fmt.Sprintf("chat:room:%s:auth", roomID)
+	_, err := admitRoomMember(roomID, userID)
+	return err
+}
 
-	// Add user to authorized members set
-	err := db.Valkey.Client.SAdd(db.Valkey.Ctx, authKey, userID).Err()
+// admitRoomMember authorizes the user like AddUserToRoomAuthMembers, it reports whether
+// this call added them, so a failing step after it only undoes its own admission
+func admitRoomMember(roomID, userID string) (bool, error) {
+	admitted, added, err := db.Valkey.AdmitRoomMember(roomID, userID)
 	if err != nil {
-		return fmt.Errorf("failed to add user to authorized members: %w", err)
+		return false, fmt.Errorf("failed to add user to authorized members: %w", err)
+	}
+	if !admitted {
+		return false, ErrRoomFull
 	}
 
-	// Reset expiration to maintain consistency
-	db.Valkey.Client.Expire(db.Valkey.Ctx, authKey, 24*time.Hour)
-
-	return nil
+	return added, nil
 }
 
 // Remove user from authorized members
@@ -191,9 +220,5 @@ func AddUserAsRoomAdmin(roomID, userID string) error {
 		return fmt.Errorf("failed to add user to admins: %w", err)
 	}
 
-	// Reset expiration
-	db.Valkey.Client.Expire(db.Valkey.Ctx, authKey, 24*time.Hour)
-	db.Valkey.Client.Expire(db.Valkey.Ctx, adminsKey, 24*time.Hour)
-
 	return nil
 }
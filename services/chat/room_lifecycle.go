@@ -0,0 +1,204 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Broadcasts announcing a change of the room itself, every node applies them to its copy of the room, see room_changes.go
+const (
+	RoomArchived     = "room_archived"
+	RoomUnarchived   = "room_unarchived"
+	RoomDeleted      = "room_deleted"
+	RoomOwnerChanged = "owner_changed"
+)
+
+func isLifecycle(msgType string) bool {
+	switch msgType {
+	case RoomArchived, RoomUnarchived, RoomDeleted, RoomOwnerChanged, RoomRetention:
+		return true
+	}
+	return false
+}
+
+// persistRoom drops the 24 hour expiry rooms were stored with before they became permanent
+func persistRoom(roomID string) {
+	if err := db.Valkey.PersistRoom(roomID); err != nil {
+		log.Printf("Error persisting room %s: %v", roomID, err)
+	}
+}
+
+// IsArchived reports whether the room is archived, and so read-only
+func (r *Room) IsArchived() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.Archived
+}
+
+// SetArchived archives the room, making it read-only, or brings it back
+func (cm *ChatManager) SetArchived(roomID, userID string, archived bool) error {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return fmt.Errorf("Room does not exist")
+	}
+
+	if err := checkNotDirect(room); err != nil {
+		return err
+	}
+
+	if !room.Can(userID, PermEditRoom) {
+		return fmt.Errorf("You are not allowed to edit this room")
+	}
+
+	if room.IsArchived() == archived {
+		return nil
+	}
+
+	if err := db.Valkey.SetRoomArchived(roomID, archived); err != nil {
+		log.Printf("Error archiving room %s: %v", roomID, err)
+		return fmt.Errorf("Unable to update room")
+	}
+
+	msgType, content := RoomUnarchived, "The room was unarchived"
+	if archived {
+		msgType, content = RoomArchived, "The room was archived, it is read-only now"
+	}
+	cm.announceLifecycle(room, userID, "", content, msgType)
+
+	log.Printf("User %s: %s %s", userID, msgType, roomID)
+	return nil
+}
+
+// TransferOwnership hands the room from its owner to one of its admins, the previous owner stays an admin
+func (cm *ChatManager) TransferOwnership(roomID, userID, newOwnerID string) error {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return fmt.Errorf("Room does not exist")
+	}
+
+	if err := checkNotDirect(room); err != nil {
+		return err
+	}
+
+	if !room.Can(userID, PermOwnRoom) {
+		return fmt.Errorf("Only the room's owner can transfer it")
+	}
+
+	if newOwnerID == userID || room.Role(newOwnerID) != RoleAdmin {
+		return fmt.Errorf("Ownership can only go to another admin of the room")
+	}
+
+	if err := db.Valkey.TransferRoomOwnership(roomID, userID, newOwnerID); err != nil {
+		log.Printf("Error transferring room %s to %s: %v", roomID, newOwnerID, err)
+		return fmt.Errorf("Unable to transfer ownership")
+	}
+
+	cm.announceLifecycle(room, userID, newOwnerID, cm.userName(newOwnerID)+" is the room's owner now", RoomOwnerChanged)
+
+	log.Printf("User %s transferred room %s to %s", userID, roomID, newOwnerID)
+	return nil
+}
+
+// DeleteRoom removes the room, its history and every key it has in Valkey, its active members are dropped from it
+func (cm *ChatManager) DeleteRoom(roomID, userID string) error {
+	room, exists := cm.GetRoom(roomID)
+	if !exists {
+		return fmt.Errorf("Room does not exist")
+	}
+
+	if err := checkNotDirect(room); err != nil {
+		return err
+	}
+
+	if !room.Can(userID, PermOwnRoom) {
+		return fmt.Errorf("Only the room's owner can delete it")
+	}
+
+	members := room.Members()
+	if err := db.Valkey.DeleteRoom(roomID, members); err != nil {
+		log.Printf("Error deleting room %s: %v", roomID, err)
+		return fmt.Errorf("Unable to delete room")
+	}
+
+	// What members missed of the room goes with it
+	for _, memberID := range members {
+		if err := db.Valkey.DropQueuedRoom(memberID, roomID); err != nil {
+			log.Printf("Error dropping room %s from the queue of %s: %v", roomID, memberID, err)
+		}
+	}
+
+	unindexRoom(room)
+
+	if err := db.DeleteRoomMessages(roomID); err != nil {
+		log.Printf("Error deleting history of room %s: %v", roomID, err)
+	}
+
+	// Every node tells its members and forgets the room once it delivers this
+	cm.announceLifecycle(room, userID, "", "The room was deleted", RoomDeleted)
+
+	log.Printf("User %s deleted room %s", userID, roomID)
+	return nil
+}
+
+// announceLifecycle applies a change of the room here and broadcasts it to its members on every node
+func (cm *ChatManager) announceLifecycle(room *Room, userID, targetID, content, msgType string) {
+	cm.announceChange(room, &models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    room.ID,
+		SenderID:  userID,
+		TargetID:  targetID,
+		Content:   content,
+		Type:      msgType,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// removeRoom unregisters the room if it is still the registered one
+func (cm *ChatManager) removeRoom(room *Room) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if cm.Rooms[room.ID] == room {
+		delete(cm.Rooms, room.ID)
+	}
+}
+
+// applyLifecycle updates this node's copy of the room
+func (r *Room) applyLifecycle(message *models.Message) {
+	switch message.Type {
+	case RoomRetention:
+		r.applyRetention(message)
+
+	case RoomArchived, RoomUnarchived:
+		r.mutex.Lock()
+		r.Archived = message.Type == RoomArchived
+		r.mutex.Unlock()
+
+	case RoomOwnerChanged:
+		// The previous owner is the one who handed the room over
+		previousOwnerID := message.SenderID
+
+		r.mutex.Lock()
+		r.CreatorID = message.TargetID
+		r.Admins[message.TargetID] = true
+		r.Admins[previousOwnerID] = true
+		if r.Roles == nil {
+			r.Roles = make(map[string]string)
+		}
+		r.Roles[previousOwnerID] = RoleAdmin
+		delete(r.Roles, message.TargetID)
+		r.mutex.Unlock()
+
+	case RoomDeleted:
+		// Members are dropped and the room forgotten once they are told, see settleChange
+		r.mutex.Lock()
+		r.deleted = true
+		r.mutex.Unlock()
+	}
+}
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"raychat/models"
+	"sort"
+	"time"
+)
+
+// How long a room holds back a broadcast while waiting for the ones numbered before it
+// Nodes publish once their save commits, so the next number can arrive first
+const seqHoldTimeout = 250 * time.Millisecond
+
+// seqOrder puts a room's numbered broadcasts back in sequence order before they go out,
+// so a client's last Seq never passes one it hasn't seen. It is only used on the room's goroutine
+type seqOrder struct {
+	last    int64                     // highest number sent, 0 until the first since subscribing
+	pending map[int64]*models.Message // held until the numbers before them arrive
+	timer   *time.Timer               // sends the held broadcasts anyway once it fires
+}
+
+// admit returns the broadcasts to send now that message arrived, in sequence order
+// Unnumbered broadcasts go out at once, as do late ones whose gap was already given up on
+func (o *seqOrder) admit(message *models.Message) []*models.Message {
+	switch {
+	case message.Seq == 0 || message.Seq <= o.last:
+		return []*models.Message{message}
+	case o.last == 0 || message.Seq == o.last+1:
+		o.last = message.Seq
+		return o.drain([]*models.Message{message})
+	}
+
+	if o.pending == nil {
+		o.pending = make(map[int64]*models.Message)
+	}
+	o.pending[message.Seq] = message
+	return nil
+}
+
+// drain appends the held broadcasts that now follow without a gap
+func (o *seqOrder) drain(ready []*models.Message) []*models.Message {
+	for {
+		message, held := o.pending[o.last+1]
+		if !held {
+			break
+		}
+		delete(o.pending, o.last+1)
+		o.last++
+		ready = append(ready, message)
+	}
+
+	if len(o.pending) == 0 {
+		o.stopTimer()
+	}
+	return ready
+}
+
+// release gives up on the gaps and returns every held broadcast in sequence order
+func (o *seqOrder) release() []*models.Message {
+	seqs := make([]int64, 0, len(o.pending))
+	for seq := range o.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	ready := make([]*models.Message, 0, len(seqs))
+	for _, seq := range seqs {
+		ready = append(ready, o.pending[seq])
+		o.last = seq
+	}
+
+	o.pending = nil
+	o.stopTimer()
+	return ready
+}
+
+// reset forgets the order, the room's next broadcast starts it over
+func (o *seqOrder) reset() {
+	o.last = 0
+	o.pending = nil
+	o.stopTimer()
+}
+
+func (o *seqOrder) stopTimer() {
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+}
+
+// deliverInOrder sends the broadcast to the room's members once the ones numbered
+// before it went out, it runs on the room's goroutine
+// Direct rooms aren't held back, their messages go to the users and leave gaps here
+func (r *Room) deliverInOrder(message *models.Message) {
+	if r.IsDirect() {
+		r.fanOut(message)
+		return
+	}
+
+	for _, ready := range r.order.admit(message) {
+		r.fanOut(ready)
+	}
+
+	if len(r.order.pending) > 0 && r.order.timer == nil {
+		var timer *time.Timer
+		timer = time.AfterFunc(seqHoldTimeout, func() {
+			r.post(func() {
+				// The gap may have filled meanwhile, then this timer is no longer the room's
+				if r.order.timer != timer {
+					return
+				}
+				for _, ready := range r.order.release() {
+					r.fanOut(ready)
+				}
+			})
+		})
+		r.order.timer = timer
+	}
+}
@@ -0,0 +1,169 @@
+package chat
+
+import (
+	"raychat/models"
+	"testing"
+	"time"
+)
+
+// seqs returns the sequence numbers of the messages
+func seqs(messages []*models.Message) []int64 {
+	numbers := make([]int64, len(messages))
+	for i, msg := range messages {
+		numbers[i] = msg.Seq
+	}
+	return numbers
+}
+
+func equalSeqs(got, want []int64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Broadcasts that arrive ahead of their turn wait for the ones before them
+func TestSeqOrderAdmit(t *testing.T) {
+	tests := []struct {
+		name     string
+		arrivals []int64
+		want     []int64 // sent by the time the last one arrived
+		held     int
+	}{
+		{"in order", []int64{5, 6, 7}, []int64{5, 6, 7}, 0},
+		{"swapped", []int64{5, 7, 6}, []int64{5, 6, 7}, 0},
+		{"reversed", []int64{5, 8, 7, 6}, []int64{5, 6, 7, 8}, 0},
+		{"gap", []int64{5, 7, 8}, []int64{5}, 2},
+		{"unnumbered", []int64{5, 0, 7, 0}, []int64{5, 0, 0}, 1},
+		{"late", []int64{5, 6, 4}, []int64{5, 6, 4}, 0},
+		{"duplicate", []int64{5, 5}, []int64{5, 5}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var order seqOrder
+			sent := make([]*models.Message, 0)
+			for _, seq := range tt.arrivals {
+				sent = append(sent, order.admit(&models.Message{Seq: seq})...)
+			}
+
+			if got := seqs(sent); !equalSeqs(got, tt.want) {
+				t.Errorf("sent %v, want %v", got, tt.want)
+			}
+			if len(order.pending) != tt.held {
+				t.Errorf("holding %d, want %d", len(order.pending), tt.held)
+			}
+		})
+	}
+}
+
+// Giving up on a gap sends what was held in order and moves past it
+func TestSeqOrderRelease(t *testing.T) {
+	var order seqOrder
+	for _, seq := range []int64{5, 9, 7} {
+		order.admit(&models.Message{Seq: seq})
+	}
+
+	if got := seqs(order.release()); !equalSeqs(got, []int64{7, 9}) {
+		t.Errorf("released %v, want [7 9]", got)
+	}
+	if got := seqs(order.admit(&models.Message{Seq: 10})); !equalSeqs(got, []int64{10}) {
+		t.Errorf("after release sent %v, want [10]", got)
+	}
+
+	order.reset()
+	if got := seqs(order.admit(&models.Message{Seq: 3})); !equalSeqs(got, []int64{3}) {
+		t.Errorf("after reset sent %v, want [3]", got)
+	}
+}
+
+// receive returns the sequence numbers of the messages on the channel, waiting up to timeout for count of them
+func receive(send <-chan *models.Message, count int, timeout time.Duration) []int64 {
+	received := make([]int64, 0, count)
+	deadline := time.After(timeout)
+	for len(received) < count {
+		select {
+		case msg := <-send:
+			received = append(received, msg.Seq)
+		case <-deadline:
+			return received
+		}
+	}
+	return received
+}
+
+// A room sends its members broadcasts in sequence order, and stops waiting for a missing one after a while
+func TestDeliverInSeqOrder(t *testing.T) {
+	room := NewRoom("lounge", "", "creator", false)
+	client := NewClient("alice", "", nil, nil)
+	room.ActiveMembers[client.UserID] = client
+
+	for _, seq := range []int64{1, 3, 2} {
+		room.deliver(&models.Message{RoomID: room.ID, Seq: seq, Type: "message"})
+	}
+	if got := receive(client.Send, 3, time.Second); !equalSeqs(got, []int64{1, 2, 3}) {
+		t.Errorf("received %v, want [1 2 3]", got)
+	}
+
+	room.deliver(&models.Message{RoomID: room.ID, Seq: 5, Type: "message"})
+	if got := receive(client.Send, 1, seqHoldTimeout/2); len(got) != 0 {
+		t.Errorf("received %v before 4 or the timeout", got)
+	}
+	if got := receive(client.Send, 1, time.Second); !equalSeqs(got, []int64{5}) {
+		t.Errorf("received %v after the timeout, want [5]", got)
+	}
+}
+
+// A direct room's numbers have gaps of the messages sent to the users, nothing waits on them
+func TestDeliverDirectUnordered(t *testing.T) {
+	room := NewRoom("dm", "", "alice", false)
+	room.RoomType = RoomTypeDirect
+	client := NewClient("alice", "", nil, nil)
+	room.ActiveMembers[client.UserID] = client
+
+	for _, seq := range []int64{1, 3} {
+		room.deliver(&models.Message{RoomID: room.ID, Seq: seq, Type: "reaction"})
+	}
+	if got := receive(client.Send, 2, seqHoldTimeout/2); !equalSeqs(got, []int64{1, 3}) {
+		t.Errorf("received %v, want [1 3]", got)
+	}
+}
+
+// Replays interleave messages and events by sequence number
+func TestMergeBySeq(t *testing.T) {
+	numbered := func(numbers ...int64) []*models.Message {
+		messages := make([]*models.Message, len(numbers))
+		for i, seq := range numbers {
+			messages[i] = &models.Message{Seq: seq}
+		}
+		return messages
+	}
+
+	tests := []struct {
+		name     string
+		messages []int64
+		events   []int64
+		limit    int
+		want     []int64
+	}{
+		{"interleaved", []int64{1, 4, 5}, []int64{2, 3, 6}, 10, []int64{1, 2, 3, 4, 5, 6}},
+		{"only messages", []int64{1, 2}, nil, 10, []int64{1, 2}},
+		{"only events", nil, []int64{3, 4}, 10, []int64{3, 4}},
+		{"limited", []int64{1, 4, 5}, []int64{2, 3, 6}, 4, []int64{1, 2, 3, 4}},
+		{"empty", nil, nil, 10, []int64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := seqs(mergeBySeq(numbered(tt.messages...), numbered(tt.events...), tt.limit))
+			if !equalSeqs(got, tt.want) {
+				t.Errorf("merged %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package chat
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"raychat/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Slow-consumer policies, selected with SLOW_CONSUMER_POLICY
+const (
+	PolicyDropOldest = "drop_oldest" // make room by dropping the oldest queued message
+	PolicyDropNewest = "drop_newest" // drop the new message and send a "gap" notice later
+	PolicyDisconnect = "disconnect"  // close the connection so the client reconnects and resumes
+)
+
+// How often drop_oldest retries before dropping the new message as well
+const dropOldestAttempts = 3
+
+// Times each policy triggered, served on the internal metrics listener, see ServeMetrics
+var slowConsumerMetrics = expvar.NewMap("slow_consumer")
+
+// SlowConsumerPolicy decides what happens to a message for a client whose Send buffer is full
+type SlowConsumerPolicy interface {
+	// Name identifies the policy in configuration and metrics
+	Name() string
+
+	// Overflow handles a message that did not fit in the client's Send buffer, it reports
+	// whether the message was queued after all. It runs with the client's send lock held.
+	Overflow(c *Client, msg *models.Message) bool
+}
+
+// NewSlowConsumerPolicy returns the policy with the given name
+func NewSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	switch name {
+	case PolicyDropOldest:
+		return dropOldestPolicy{}, nil
+	case PolicyDropNewest:
+		return dropNewestPolicy{}, nil
+	case PolicyDisconnect:
+		return disconnectPolicy{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown slow consumer policy %q", name)
+}
+
+type dropOldestPolicy struct{}
+
+func (dropOldestPolicy) Name() string { return PolicyDropOldest }
+
+func (dropOldestPolicy) Overflow(c *Client, msg *models.Message) bool {
+	for attempt := 0; attempt < dropOldestAttempts; attempt++ {
+		select {
+		case <-c.Send:
+			slowConsumerMetrics.Add(PolicyDropOldest, 1)
+		default:
+		}
+
+		select {
+		case c.Send <- msg:
+			return true
+		default:
+		}
+	}
+
+	// Other senders keep filling the buffer, the new message goes too
+	slowConsumerMetrics.Add(PolicyDropOldest, 1)
+	return false
+}
+
+type dropNewestPolicy struct{}
+
+func (dropNewestPolicy) Name() string { return PolicyDropNewest }
+
+func (dropNewestPolicy) Overflow(c *Client, msg *models.Message) bool {
+	slowConsumerMetrics.Add(PolicyDropNewest, 1)
+	c.recordGap(msg)
+	return false
+}
+
+type disconnectPolicy struct{}
+
+func (disconnectPolicy) Name() string { return PolicyDisconnect }
+
+func (disconnectPolicy) Overflow(c *Client, msg *models.Message) bool {
+	if !c.setCloseReason(websocket.CloseTryAgainLater, "slow consumer") {
+		// Already on its way out
+		return false
+	}
+
+	slowConsumerMetrics.Add(PolicyDisconnect, 1)
+	log.Printf("Send buffer full, disconnecting client %s", c.UserID)
+
+	if c.Manager != nil {
+		go c.Manager.Unregister(c)
+	}
+	return false
+}
+
+// overflowPolicy returns the manager's slow-consumer policy
+func (c *Client) overflowPolicy() SlowConsumerPolicy {
+	if c.Manager != nil && c.Manager.slowConsumer != nil {
+		return c.Manager.slowConsumer
+	}
+
+	return disconnectPolicy{}
+}
+
+// recordGap remembers a message dropped for the client, to be reported in a "gap" notice
+func (c *Client) recordGap(msg *models.Message) {
+	c.overflowMutex.Lock()
+	defer c.overflowMutex.Unlock()
+
+	if c.gap == nil {
+		c.gap = &models.Gap{Since: time.Now().Unix()}
+	}
+	c.gap.Dropped++
+
+	if msg.RoomID == "" {
+		return
+	}
+	for _, roomID := range c.gap.Rooms {
+		if roomID == msg.RoomID {
+			return
+		}
+	}
+	c.gap.Rooms = append(c.gap.Rooms, msg.RoomID)
+}
+
+// flushGap queues the pending "gap" notice, it reports false while the buffer is still too full for it.
+// The caller must hold the client's send lock.
+func (c *Client) flushGap() bool {
+	c.overflowMutex.Lock()
+	defer c.overflowMutex.Unlock()
+
+	if c.gap == nil {
+		return true
+	}
+
+	payload, err := json.Marshal(c.gap)
+	if err != nil {
+		log.Printf("Error marshaling gap: %v", err)
+		return true
+	}
+
+	notice := &models.Message{
+		ID:        uuid.New().String(),
+		SenderID:  "system",
+		Content:   fmt.Sprintf("%d messages were dropped because the connection fell behind", c.gap.Dropped),
+		Type:      "gap",
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	}
+
+	select {
+	case c.Send <- notice:
+		c.gap = nil
+		slowConsumerMetrics.Add("gap_notices", 1)
+		return true
+	default:
+		return false
+	}
+}
+
+// setCloseReason records why the connection is being closed, only the first reason is kept
+func (c *Client) setCloseReason(code int, text string) bool {
+	c.overflowMutex.Lock()
+	defer c.overflowMutex.Unlock()
+
+	if c.closeCode != 0 {
+		return false
+	}
+
+	c.closeCode = code
+	c.closeText = text
+	return true
+}
+
+// closeReason returns the code and text the connection is closed with, a zero code when none was set
+func (c *Client) closeReason() (int, string) {
+	c.overflowMutex.Lock()
+	defer c.overflowMutex.Unlock()
+
+	return c.closeCode, c.closeText
+}
+
+// isClosing reports whether the client was closed or is being disconnected
+func (c *Client) isClosing() bool {
+	c.sendMutex.RLock()
+	closed := c.closed
+	c.sendMutex.RUnlock()
+
+	code, _ := c.closeReason()
+	return closed || code != 0
+}
+
+// loadSlowConsumerPolicy reads SLOW_CONSUMER_POLICY, disconnecting slow clients by default
+func loadSlowConsumerPolicy() SlowConsumerPolicy {
+	name := os.Getenv("SLOW_CONSUMER_POLICY")
+	if name == "" {
+		name = PolicyDisconnect
+	}
+
+	policy, err := NewSlowConsumerPolicy(name)
+	if err != nil {
+		log.Printf("%v, disconnecting slow clients", err)
+		return disconnectPolicy{}
+	}
+
+	log.Printf("Slow consumer policy: %s", policy.Name())
+	return policy
+}
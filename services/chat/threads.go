@@ -0,0 +1,149 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	db "raychat/database"
+	"raychat/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// loadThreadParent returns the message a reply in the room is answering
+// Threads are one level deep, replies can't be answered themselves
+func loadThreadParent(roomID, parentID string) (*models.Message, error) {
+	parent, err := db.GetMessage(parentID)
+	if err != nil || parent.RoomID != roomID {
+		return nil, fmt.Errorf("Message does not exist")
+	}
+
+	if parent.ParentID != "" {
+		return nil, fmt.Errorf("Replies can't start a thread, reply to the original message")
+	}
+
+	if parent.Deleted {
+		return nil, fmt.Errorf("Message was deleted")
+	}
+
+	return parent, nil
+}
+
+// threadReplied subscribes the thread participants and notifies the other subscribers of the reply
+// Subscribers who can no longer read the room are dropped instead
+func (cm *ChatManager) threadReplied(parent, reply *models.Message) {
+	room, exists := cm.GetRoom(parent.RoomID)
+	if !exists {
+		return
+	}
+
+	if err := db.Valkey.SubscribeToThread(parent.RoomID, parent.ID, parent.SenderID, reply.SenderID); err != nil {
+		log.Printf("Error subscribing to thread %s: %v", parent.ID, err)
+	}
+
+	subscribers, err := db.Valkey.GetThreadSubscribers(parent.RoomID, parent.ID)
+	if err != nil {
+		log.Printf("Error loading subscribers of thread %s: %v", parent.ID, err)
+		return
+	}
+
+	gone := make([]string, 0)
+	for _, userID := range subscribers {
+		if userID == reply.SenderID {
+			continue
+		}
+
+		if !room.Can(userID, PermRead) {
+			gone = append(gone, userID)
+			continue
+		}
+
+		notification := *reply
+		notification.Type = "thread_reply"
+		notification.TargetID = reply.ID
+		notification.ID = uuid.New().String()
+		cm.SendToUser(&UserMessage{UserID: userID, Message: &notification})
+	}
+
+	if len(gone) > 0 {
+		if err := db.Valkey.UnsubscribeFromThread(parent.RoomID, parent.ID, gone...); err != nil {
+			log.Printf("Error dropping subscribers of thread %s: %v", parent.ID, err)
+		}
+	}
+}
+
+// unfollowThreads drops the user from the threads they follow in the room once they leave it or are removed
+func unfollowThreads(roomID, userID string) {
+	if err := db.Valkey.UnsubscribeFromThreads(roomID, userID); err != nil {
+		log.Printf("Error dropping %s from the threads of room %s: %v", userID, roomID, err)
+	}
+}
+
+// GetThreadReplies returns a page of the replies to a message in a room the user can read
+func GetThreadReplies(roomID, parentID, userID, before string, limit int) (*models.HistoryPage, error) {
+	if !manager.CanReadRoom(roomID, userID) {
+		return nil, fmt.Errorf("not authorized to read room %s", roomID)
+	}
+
+	parent, err := db.GetMessage(parentID)
+	if err != nil || parent.RoomID != roomID {
+		return nil, fmt.Errorf("message %s not found in room %s", parentID, roomID)
+	}
+
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	} else if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	replies, nextCursor, err := db.GetThreadReplies(parentID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := attachReactions(replies); err != nil {
+		return nil, err
+	}
+
+	return &models.HistoryPage{
+		RoomID:     roomID,
+		ParentID:   parentID,
+		Messages:   replies,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+// handleThread answers a "thread" request with a page of replies to the target message
+func (c *Client) handleThread(msg *models.Message) {
+	var req models.HistoryRequest
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			c.reject(NewMessage(msg.RoomID, "system", "Invalid thread request", "error"))
+			return
+		}
+	}
+
+	page, err := GetThreadReplies(msg.RoomID, msg.TargetID, c.UserID, req.Before, req.Limit)
+	if err != nil {
+		log.Printf("Error loading thread %s for %s: %v", msg.TargetID, c.UserID, err)
+		c.reject(NewMessage(msg.RoomID, "system", "Unable to load thread", "error"))
+		return
+	}
+
+	payload, err := json.Marshal(page)
+	if err != nil {
+		log.Printf("Error marshaling thread page: %v", err)
+		return
+	}
+
+	sendToClient(c, &models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    msg.RoomID,
+		SenderID:  "system",
+		TargetID:  msg.TargetID,
+		Type:      "thread",
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	})
+}
@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"raychat/models"
+	"sync"
+	"time"
+)
+
+const (
+	// A typing indicator expires when no "typing_stop" arrives within this time
+	typingTimeout = 6 * time.Second
+
+	// Repeated "typing_start" from the same user in a room are not fanned out more often than this
+	typingThrottle = 2 * time.Second
+)
+
+// TypingTracker fans typing indicators out to the room and expires the stale ones
+/*
+Typing events are ephemeral: they are never stored and are only sent to the
+other active members of the room. Clients usually repeat "typing_start" while
+the user types, so the tracker throttles them and stops the indicator on its own
+when the client goes quiet without sending "typing_stop".
+*/
+type TypingTracker struct {
+	manager *ChatManager
+	typing  map[string]*typingState // keyed by room ID and user ID
+	mutex   sync.Mutex
+}
+
+type typingState struct {
+	timer    *time.Timer
+	lastSent time.Time
+}
+
+// NewTypingTracker creates a typing tracker for the manager's rooms
+func NewTypingTracker(manager *ChatManager) *TypingTracker {
+	return &TypingTracker{
+		manager: manager,
+		typing:  make(map[string]*typingState),
+	}
+}
+
+func typingKey(roomID, userID string) string {
+	return roomID + "/" + userID
+}
+
+// isEphemeral reports whether a message type is only meant for the sender's peers
+func isEphemeral(msgType string) bool {
+	return msgType == "typing_start" || msgType == "typing_stop"
+}
+
+// Start marks the user typing in the room and restarts the expiry timer
+func (t *TypingTracker) Start(roomID, userID string) {
+	now := time.Now()
+	key := typingKey(roomID, userID)
+
+	t.mutex.Lock()
+	state, exists := t.typing[key]
+	if !exists {
+		state = &typingState{}
+		state.timer = time.AfterFunc(typingTimeout, func() {
+			t.Stop(roomID, userID)
+		})
+		t.typing[key] = state
+	} else {
+		state.timer.Reset(typingTimeout)
+	}
+
+	throttled := now.Sub(state.lastSent) < typingThrottle
+	if !throttled {
+		state.lastSent = now
+	}
+	t.mutex.Unlock()
+
+	if !throttled {
+		t.manager.Broadcast(NewMessage(roomID, userID, "", "typing_start"))
+	}
+}
+
+// Stop clears the user's typing indicator in the room, it does nothing if the user wasn't typing
+func (t *TypingTracker) Stop(roomID, userID string) {
+	key := typingKey(roomID, userID)
+
+	t.mutex.Lock()
+	state, exists := t.typing[key]
+	if exists {
+		state.timer.Stop()
+		delete(t.typing, key)
+	}
+	t.mutex.Unlock()
+
+	if exists {
+		t.manager.Broadcast(NewMessage(roomID, userID, "", "typing_stop"))
+	}
+}
+
+// handleTyping processes "typing_start" and "typing_stop" from an active member of the room
+func (c *Client) handleTyping(msg *models.Message) {
+	if !c.Manager.IsActiveMember(msg.RoomID, c.UserID) {
+		return
+	}
+
+	if msg.Type == "typing_start" {
+		// Members who can't post don't get to look like they're about to
+		if !c.Manager.Authorize(msg.RoomID, c.UserID, PermPost) || c.Manager.IsMuted(msg.RoomID, c.UserID) {
+			return
+		}
+		c.Manager.typing.Start(msg.RoomID, c.UserID)
+	} else {
+		c.Manager.typing.Stop(msg.RoomID, c.UserID)
+	}
+}
@@ -22,6 +22,8 @@ func NewRoom(roomId, name string, cretorID string, isPrivate bool) *Room {
 		AuthorizedMembers: map[string]bool{cretorID: true}, //Add cretor as the first member
 		ActiveMembers:     make(map[string]*Client),        //Initially empty
 		Admins:            map[string]bool{cretorID: true}, //Creator is automatically an admin
+		Roles:             make(map[string]string),
+		RolePermissions:   make(map[string][]string),
 		IsPrivate:         isPrivate,
 		CreatedAt:         time.Now(),
 	}
@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net"
+	db "raychat/database"
+	"sync"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeValkey answers the commands of db.Valkey from a hook, nothing is dialed.
+// Commands without a reply fail, which the chat service logs and lives with.
+type fakeValkey struct {
+	replies map[string]func(args []interface{}) (interface{}, error) // by lowercased command name
+	calls   [][]interface{}
+	mutex   sync.Mutex
+}
+
+// useFakeValkey points db.Valkey at a fake until the test ends
+func useFakeValkey(t *testing.T, replies map[string]func(args []interface{}) (interface{}, error)) *fakeValkey {
+	t.Helper()
+
+	fake := &fakeValkey{replies: replies}
+	client := redis.NewClient(&redis.Options{Addr: "fake:6379"})
+	client.AddHook(fake)
+
+	previous := db.Valkey
+	db.Valkey = &db.ValkeyChatStore{Client: client, Ctx: context.Background()}
+	t.Cleanup(func() { db.Valkey = previous })
+
+	return fake
+}
+
+// waitBackground makes the test wait, before the fake goes, for the work the manager runs in the background
+func waitBackground(t *testing.T, cm *ChatManager) {
+	t.Cleanup(func() {
+		done := make(chan struct{})
+		cm.runInBackground(func() { close(done) })
+		<-done
+	})
+}
+
+// count returns how many commands matched
+func (f *fakeValkey) count(match func(args []interface{}) bool) int {
+	return len(f.matching(match))
+}
+
+// matching returns the arguments of the commands that matched, in the order they were sent
+func (f *fakeValkey) matching(match func(args []interface{}) bool) [][]interface{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	matched := make([][]interface{}, 0)
+	for _, args := range f.calls {
+		if match(args) {
+			matched = append(matched, args)
+		}
+	}
+	return matched
+}
+
+func (f *fakeValkey) process(cmd redis.Cmder) {
+	f.mutex.Lock()
+	f.calls = append(f.calls, cmd.Args())
+	reply, exists := f.replies[cmd.Name()]
+	f.mutex.Unlock()
+
+	if !exists {
+		cmd.SetErr(fmt.Errorf("fake valkey: unexpected %s", cmd.Name()))
+		return
+	}
+
+	value, err := reply(cmd.Args())
+	if err != nil {
+		cmd.SetErr(err)
+		return
+	}
+
+	switch cmd := cmd.(type) {
+	case *redis.Cmd:
+		cmd.SetVal(value)
+	case *redis.IntCmd:
+		cmd.SetVal(value.(int64))
+	case *redis.BoolCmd:
+		cmd.SetVal(value.(bool))
+	case *redis.FloatCmd:
+		cmd.SetVal(value.(float64))
+	case *redis.StringCmd:
+		cmd.SetVal(value.(string))
+	case *redis.StatusCmd:
+		cmd.SetVal(value.(string))
+	case *redis.SliceCmd:
+		cmd.SetVal(value.([]interface{}))
+	case *redis.StringSliceCmd:
+		cmd.SetVal(value.([]string))
+	case *redis.XMessageSliceCmd:
+		cmd.SetVal(value.([]redis.XMessage))
+	default:
+		cmd.SetErr(fmt.Errorf("fake valkey: no reply type for %s", cmd.Name()))
+	}
+}
+
+func (f *fakeValkey) DialHook(redis.DialHook) redis.DialHook {
+	return func(context.Context, string, string) (net.Conn, error) {
+		return nil, fmt.Errorf("fake valkey doesn't dial")
+	}
+}
+
+func (f *fakeValkey) ProcessHook(redis.ProcessHook) redis.ProcessHook {
+	return func(_ context.Context, cmd redis.Cmder) error {
+		f.process(cmd)
+		return cmd.Err()
+	}
+}
+
+func (f *fakeValkey) ProcessPipelineHook(redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(_ context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			f.process(cmd)
+		}
+		return nil
+	}
+}